@@ -1,58 +1,163 @@
 package checker
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"time"
 
 	"handshake/common"
-
-	"github.com/btcsuite/btcd/wire"
+	"handshake/message"
 )
 
+// ErrInvalidHandshake is returned when a peer sends a message that should not
+// be sent before the handshake is complete.
+var ErrInvalidHandshake = errors.New("invalid message during handshake")
+
 // we use this to simply skip the next message in tcp stack
 func ReadMessageWithEncodingN(r io.Reader, protocolVersion uint32, network common.BitcoinNet) error {
-	_, _, _, err := wire.ReadMessageWithEncodingN(r, protocolVersion, wire.BitcoinNet(network), wire.LatestEncoding)
+	_, _, _, err := message.ReadMessageWithEncodingN(r, protocolVersion, network, message.WitnessEncoding)
 	return err
 }
 
-func readMessage(conn net.Conn, protocolVersion uint32, network common.BitcoinNet) (wire.Message, []byte, error) {
-	_, msg, buf, err := wire.ReadMessageWithEncodingN(conn,
-		protocolVersion, wire.BitcoinNet(network), wire.LatestEncoding)
+func readMessage(conn net.Conn, protocolVersion uint32, network common.BitcoinNet) (message.Message, []byte, error) {
+	_, msg, buf, err := message.ReadMessageWithEncodingN(conn,
+		protocolVersion, network, message.WitnessEncoding)
 	return msg, buf, err
 }
 
+// WaitToFinishNegotiation waits up to a second for the remote verack.
 func WaitToFinishNegotiation(conn net.Conn, protocolVersion uint32, network common.BitcoinNet) error {
-	verack := make(chan error)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	return WaitToFinishNegotiationContext(ctx, conn, protocolVersion, network)
+}
+
+// WaitToFinishNegotiationContext waits for the remote verack until ctx is done.
+// On cancellation the pending read is interrupted through the connection read
+// deadline, so no goroutine outlives the call.  The deadline is left in the
+// past then, reset it before reading from conn again.
+func WaitToFinishNegotiationContext(ctx context.Context, conn net.Conn, protocolVersion uint32, network common.BitcoinNet) error {
+	err := readContext(ctx, conn, func() error {
+		return waitForVerAck(conn, protocolVersion, network)
+	})
+	if err == context.DeadlineExceeded {
+		return &message.ErrHandshakeTimeout{Phase: message.PhaseVerAck, Err: err}
+	}
+	return err
+}
+
+// readContext runs read until it returns or ctx is done.  On cancellation the
+// pending read is interrupted through the connection read deadline and ctx.Err
+// is returned once read has returned.  The deadline the caller had set can't
+// be recovered from conn, so it is left in the past for the caller to reset
+// rather than cleared.
+func readContext(ctx context.Context, conn net.Conn, read func() error) error {
+	// buffered so the reader never blocks on a result nobody receives
+	result := make(chan error, 1)
 
-	go func(verack chan error) {
+	go func() {
+		result <- read()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		// unblock the reader and wait for it to return
+		conn.SetReadDeadline(time.Now())
+		<-result
+
+		return ctx.Err()
+	}
+}
+
+// handshakeEnd is what a peer sends after its version: feature negotiation,
+// which may also be sent right after verack, and the verack.
+var handshakeEnd = Expect(Allow(SendAddrV2, WtxidRelay, SendHeaders, SendCmpct, FeeFilter), VerAck)
+
+// waitForVerAck reads messages until verack, skipping feature negotiation and
+// unknown messages.
+func waitForVerAck(conn net.Conn, protocolVersion uint32, network common.BitcoinNet) error {
+	_, expErr := handshakeEnd.match(conn, protocolVersion, network)
+	if expErr == nil {
+		log.Debugf("Received verack from %s", conn.RemoteAddr())
+		return nil
+	}
+
+	var unexpected *message.ErrUnexpectedMessage
+	if errors.As(expErr, &unexpected) {
+		// This is triggered if the peer sends, for example, a
+		// GETDATA message during this negotiation.
+		return fmt.Errorf("%w: %w", ErrInvalidHandshake, expErr)
+	}
+	return expErr.Err
+}
+
+// MeasureRTT sends a ping right after the handshake and waits up to a second
+// for the matching pong, returning the round trip time.
+func MeasureRTT(conn net.Conn, protocolVersion uint32, network common.BitcoinNet) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	return MeasureRTTContext(ctx, conn, protocolVersion, network)
+}
+
+// MeasureRTTContext sends a ping and waits for the matching pong until ctx is
+// done.  Messages the node sends meanwhile are skipped and its pings answered.
+// Pong requires a protocol version after BIP0031.  When ctx is done first, the
+// read deadline of conn is left in the past.
+func MeasureRTTContext(ctx context.Context, conn net.Conn, protocolVersion uint32, network common.BitcoinNet) (time.Duration, error) {
+	if protocolVersion <= message.BIP0031Version {
+		return 0, fmt.Errorf("pong is not supported by protocol version %d",
+			protocolVersion)
+	}
+
+	nonce, err := common.RandomUint64()
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	err = message.WriteMessageWithEncodingN(conn, &message.MsgPing{Nonce: nonce},
+		protocolVersion, network, message.WitnessEncoding)
+	if err != nil {
+		return 0, err
+	}
+
+	var rtt time.Duration
+	err = readContext(ctx, conn, func() error {
 		for {
 			remoteMsg, _, err := readMessage(conn, protocolVersion, network)
-			if err == wire.ErrUnknownMessage {
+			if err == message.ErrUnknownMessage {
 				continue
 			} else if err != nil {
-				verack <- err
+				return err
 			}
 
-			switch remoteMsg.(type) {
-			case *wire.MsgSendAddrV2:
-				// skip MsgSendAddrV2 message
-				continue
-			case *wire.MsgVerAck:
-				verack <- nil
-			default:
-				// This is triggered if the peer sends, for example, a
-				// GETDATA message during this negotiation.
-				verack <- wire.ErrInvalidHandshake
+			switch m := remoteMsg.(type) {
+			case *message.MsgPong:
+				if m.Nonce == nonce {
+					rtt = time.Since(start)
+					log.Debugf("Round trip time to %s is %v",
+						conn.RemoteAddr(), rtt)
+					return nil
+				}
+			case *message.MsgPing:
+				err := message.WriteMessageWithEncodingN(conn,
+					&message.MsgPong{Nonce: m.Nonce}, protocolVersion,
+					network, message.WitnessEncoding)
+				if err != nil {
+					return err
+				}
 			}
 		}
-	}(verack)
-
-	select {
-	case err := <-verack:
-		return err
-	case <-time.After(1 * time.Second):
-		return errors.New("ack message not received in time")
+	})
+	if err == context.DeadlineExceeded {
+		return 0, &message.ErrHandshakeTimeout{Phase: message.PhasePong, Err: err}
 	}
+	return rtt, err
 }
@@ -0,0 +1,121 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"handshake/common"
+	"handshake/message"
+)
+
+const protocolVersion = 70016
+
+func writeMessage(t *testing.T, conn net.Conn, msg message.Message) {
+	err := message.WriteMessageWithEncodingN(conn, msg, protocolVersion, common.SimNet, message.WitnessEncoding)
+	if err != nil {
+		t.Errorf("write failed: %+v", err)
+	}
+}
+
+func TestWaitToFinishNegotiation(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	go func() {
+		writeMessage(t, remote, &message.MsgSendAddrV2{})
+		writeMessage(t, remote, &message.MsgVerAck{})
+	}()
+
+	err := WaitToFinishNegotiation(local, protocolVersion, common.SimNet)
+	if err != nil {
+		t.Fatalf("verack should have been received: %+v", err)
+	}
+}
+
+func TestWaitToFinishNegotiationContextCancel(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := WaitToFinishNegotiationContext(ctx, local, protocolVersion, common.SimNet)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %+v", err)
+	}
+
+	// the reader is gone, so the connection can still be used once the
+	// caller reset the deadline
+	local.SetReadDeadline(time.Time{})
+	go writeMessage(t, remote, &message.MsgVerAck{})
+	err = WaitToFinishNegotiation(local, protocolVersion, common.SimNet)
+	if err != nil {
+		t.Fatalf("connection should be usable after cancellation: %+v", err)
+	}
+}
+
+func TestWaitToFinishNegotiationTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := WaitToFinishNegotiationContext(ctx, local, protocolVersion, common.SimNet)
+	var timeoutErr *message.ErrHandshakeTimeout
+	if !errors.As(err, &timeoutErr) || timeoutErr.Phase != message.PhaseVerAck {
+		t.Fatalf("expected verack timeout, got %+v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timeout should wrap context.DeadlineExceeded")
+	}
+}
+
+func TestWaitToFinishNegotiationUnexpected(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	go writeMessage(t, remote, &message.MsgPing{Nonce: 1})
+
+	err := WaitToFinishNegotiation(local, protocolVersion, common.SimNet)
+	if !errors.Is(err, ErrInvalidHandshake) {
+		t.Errorf("expected ErrInvalidHandshake, got %+v", err)
+	}
+	var unexpected *message.ErrUnexpectedMessage
+	if !errors.As(err, &unexpected) || unexpected.Command != message.CmdPing {
+		t.Errorf("expected unexpected ping, got %+v", err)
+	}
+}
+
+func TestMeasureRTT(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	go func() {
+		_, msg, _, err := message.ReadMessageWithEncodingN(remote, protocolVersion, common.SimNet, message.WitnessEncoding)
+		if err != nil {
+			t.Errorf("read failed: %+v", err)
+			return
+		}
+		// unrelated messages and stale pongs are skipped
+		writeMessage(t, remote, &message.MsgWtxidRelay{})
+		writeMessage(t, remote, &message.MsgPong{Nonce: msg.(*message.MsgPing).Nonce + 1})
+		writeMessage(t, remote, &message.MsgPong{Nonce: msg.(*message.MsgPing).Nonce})
+	}()
+
+	rtt, err := MeasureRTT(local, protocolVersion, common.SimNet)
+	if err != nil {
+		t.Fatalf("pong should have been received: %+v", err)
+	}
+	if rtt <= 0 {
+		t.Errorf("unexpected rtt %v", rtt)
+	}
+}
@@ -0,0 +1,255 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"handshake/common"
+	"handshake/message"
+)
+
+// Command names a message in an expectation.  On its own it is a step which
+// requires exactly one message of that command.
+type Command string
+
+// Commands of the messages an expectation can refer to.
+const (
+	Version     Command = message.CmdVersion
+	VerAck      Command = message.CmdVerAck
+	SendAddrV2  Command = message.CmdSendAddrV2
+	WtxidRelay  Command = message.CmdWtxidRelay
+	SendHeaders Command = message.CmdSendHeaders
+	SendCmpct   Command = message.CmdSendCmpct
+	FeeFilter   Command = message.CmdFeeFilter
+	Ping        Command = message.CmdPing
+	Pong        Command = message.CmdPong
+	Addr        Command = message.CmdAddr
+	GetAddr     Command = message.CmdGetAddr
+	AddrV2      Command = message.CmdAddrV2
+)
+
+// Step is a part of an expectation, either a Command which must be received
+// or a set of commands tolerated by Allow.
+type Step interface {
+	// commands returns the commands the step matches.
+	commands() []Command
+
+	// optional reports whether the step may match no message at all.
+	optional() bool
+}
+
+func (c Command) commands() []Command { return []Command{c} }
+func (c Command) optional() bool      { return false }
+
+// allowStep tolerates any number of messages of its commands in any order.
+type allowStep []Command
+
+func (a allowStep) commands() []Command { return a }
+func (a allowStep) optional() bool      { return true }
+
+// Allow returns a step tolerating any number of messages with the given
+// commands, in any order, before the next required command.
+func Allow(commands ...Command) Step {
+	return allowStep(commands)
+}
+
+// Expectation describes the sequence of messages expected from a peer, e.g.
+//
+//	Expect(Version).Then(Allow(SendAddrV2, WtxidRelay)).Then(VerAck).Within(2*time.Second)
+//
+// Messages unknown to the message package are skipped.  Once the last
+// required command is received the expectation is met, so trailing Allow
+// steps have no effect.  Expectations are immutable, every method returns a
+// new one.
+type Expectation struct {
+	steps   []Step
+	timeout time.Duration
+}
+
+// Expect starts an expectation with the given steps.
+func Expect(steps ...Step) *Expectation {
+	return &Expectation{steps: steps}
+}
+
+// Then returns the expectation extended by steps.
+func (e *Expectation) Then(steps ...Step) *Expectation {
+	next := &Expectation{timeout: e.timeout}
+	next.steps = append(append(next.steps, e.steps...), steps...)
+	return next
+}
+
+// Within returns the expectation which has to be met in d.  Zero means no
+// limit.
+func (e *Expectation) Within(d time.Duration) *Expectation {
+	next := *e
+	next.timeout = d
+	return &next
+}
+
+// ExpectationError reports where the messages received diverged from an
+// expectation.
+type ExpectationError struct {
+	// Transcript are the commands of the messages which matched the
+	// expectation, in order.
+	Transcript []Command
+
+	// Want are the commands acceptable at the point of divergence.
+	Want []Command
+
+	// Err is why the transcript diverged.  It is a
+	// *message.ErrUnexpectedMessage for a message which didn't match,
+	// a *message.ErrHandshakeTimeout when the expectation wasn't met in
+	// time, or the error reading from the connection.
+	Err error
+
+	// next is the required command the expectation was waiting for.
+	next Command
+}
+
+// Error returns the error as a human-readable string.
+func (e *ExpectationError) Error() string {
+	at := "at the start"
+	if len(e.Transcript) > 0 {
+		at = "after " + joinCommands(e.Transcript, ", ")
+	}
+	return fmt.Sprintf("transcript diverged %s, expected %s: %v", at,
+		joinCommands(e.Want, " or "), e.Err)
+}
+
+// Unwrap returns why the transcript diverged.
+func (e *ExpectationError) Unwrap() error {
+	return e.Err
+}
+
+// Run reads messages from conn until the expectation is met and returns them.
+// It fails with an *ExpectationError as soon as the messages diverge.
+func (e *Expectation) Run(conn net.Conn, protocolVersion uint32, network common.BitcoinNet) ([]message.Message, error) {
+	return e.RunContext(context.Background(), conn, protocolVersion, network)
+}
+
+// RunContext is like Run but gives up once ctx is done.  The pending read is
+// interrupted through the connection read deadline, which is left in the past
+// then.  Reset it before reading from conn again.
+func (e *Expectation) RunContext(ctx context.Context, conn net.Conn, protocolVersion uint32, network common.BitcoinNet) ([]message.Message, error) {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	var msgs []message.Message
+	var expErr *ExpectationError
+	readContext(ctx, conn, func() error {
+		msgs, expErr = e.match(conn, protocolVersion, network)
+		if expErr != nil {
+			return expErr
+		}
+		return nil
+	})
+	if expErr == nil {
+		return msgs, nil
+	}
+
+	// The read was interrupted, report why instead of the read error.
+	if ctx.Err() == context.DeadlineExceeded {
+		expErr.Err = &message.ErrHandshakeTimeout{
+			Phase: string(expErr.next),
+			Err:   ctx.Err(),
+		}
+	} else if ctx.Err() != nil {
+		expErr.Err = ctx.Err()
+	}
+	return msgs, expErr
+}
+
+// match reads messages until every required step matched.
+func (e *Expectation) match(conn net.Conn, protocolVersion uint32, network common.BitcoinNet) ([]message.Message, *ExpectationError) {
+	var msgs []message.Message
+	var transcript []Command
+	i := 0
+	for !e.met(i) {
+		msg, _, err := readMessage(conn, protocolVersion, network)
+		if err == message.ErrUnknownMessage {
+			continue
+		} else if err != nil {
+			return msgs, e.diverged(i, transcript, err)
+		}
+
+		cmd := Command(msg.Command())
+		next, ok := e.advance(i, cmd)
+		if !ok {
+			unexpected := &message.ErrUnexpectedMessage{Command: string(cmd)}
+			return msgs, e.diverged(i, transcript, unexpected)
+		}
+		i = next
+		msgs = append(msgs, msg)
+		transcript = append(transcript, cmd)
+	}
+
+	return msgs, nil
+}
+
+// met reports whether the steps from i on may match no message at all.
+func (e *Expectation) met(i int) bool {
+	for _, step := range e.steps[i:] {
+		if !step.optional() {
+			return false
+		}
+	}
+	return true
+}
+
+// advance matches cmd against the steps from i on and returns the step to
+// continue with.  The next required step takes precedence over the optional
+// ones before it.
+func (e *Expectation) advance(i int, cmd Command) (int, bool) {
+	j := i
+	for j < len(e.steps) && e.steps[j].optional() {
+		j++
+	}
+	if j < len(e.steps) && hasCommand(e.steps[j], cmd) {
+		return j + 1, true
+	}
+
+	for k := i; k < j; k++ {
+		if hasCommand(e.steps[k], cmd) {
+			return k, true
+		}
+	}
+	return i, false
+}
+
+// diverged returns the error for a transcript which diverged at step i.
+func (e *Expectation) diverged(i int, transcript []Command, err error) *ExpectationError {
+	expErr := &ExpectationError{Transcript: transcript, Err: err}
+	for _, step := range e.steps[i:] {
+		expErr.Want = append(expErr.Want, step.commands()...)
+		if !step.optional() {
+			expErr.next = step.commands()[0]
+			break
+		}
+	}
+	return expErr
+}
+
+// hasCommand reports whether step matches cmd.
+func hasCommand(step Step, cmd Command) bool {
+	for _, c := range step.commands() {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// joinCommands joins commands with sep.
+func joinCommands(commands []Command, sep string) string {
+	strs := make([]string, len(commands))
+	for i, c := range commands {
+		strs[i] = string(c)
+	}
+	return strings.Join(strs, sep)
+}
@@ -0,0 +1,129 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"handshake/common"
+	"handshake/message"
+)
+
+// handshakeExpectation is what a modern peer sends during the handshake
+var handshakeExpectation = Expect(Version).Then(Allow(SendAddrV2, WtxidRelay)).Then(VerAck)
+
+func testVersion() *message.MsgVersion {
+	return &message.MsgVersion{
+		ProtocolVersion: protocolVersion,
+		Timestamp:       time.Unix(time.Now().Unix(), 0),
+		UserAgent:       "/checker:0.1/",
+	}
+}
+
+func TestExpectationMet(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	go func() {
+		writeMessage(t, remote, testVersion())
+		writeMessage(t, remote, &message.MsgWtxidRelay{})
+		writeMessage(t, remote, &message.MsgSendAddrV2{})
+		writeMessage(t, remote, &message.MsgVerAck{})
+		// feature messages following the handshake
+		writeMessage(t, remote, &message.MsgSendHeaders{})
+		writeMessage(t, remote, &message.MsgSendCmpct{Announce: true, Version: 2})
+		writeMessage(t, remote, &message.MsgFeeFilter{MinFee: 1000})
+	}()
+
+	msgs, err := handshakeExpectation.Within(time.Second).Run(local, protocolVersion, common.SimNet)
+	if err != nil {
+		t.Fatalf("handshake expectation should be met: %+v", err)
+	}
+	if len(msgs) != 4 {
+		t.Errorf("expected 4 messages, got %d", len(msgs))
+	}
+
+	msgs, err = Expect(Allow(SendHeaders, SendCmpct), FeeFilter).Within(time.Second).Run(local, protocolVersion, common.SimNet)
+	if err != nil {
+		t.Fatalf("feature expectation should be met: %+v", err)
+	}
+	if fee := msgs[2].(*message.MsgFeeFilter); fee.MinFee != 1000 {
+		t.Errorf("unexpected fee filter %d", fee.MinFee)
+	}
+}
+
+func TestExpectationDiverged(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	go func() {
+		writeMessage(t, remote, testVersion())
+		writeMessage(t, remote, &message.MsgSendAddrV2{})
+		writeMessage(t, remote, &message.MsgPing{Nonce: 1})
+	}()
+
+	_, err := handshakeExpectation.Within(time.Second).Run(local, protocolVersion, common.SimNet)
+	var expErr *ExpectationError
+	if !errors.As(err, &expErr) {
+		t.Fatalf("expected an ExpectationError, got %+v", err)
+	}
+	if want := []Command{Version, SendAddrV2}; !reflect.DeepEqual(expErr.Transcript, want) {
+		t.Errorf("unexpected transcript %v", expErr.Transcript)
+	}
+	if want := []Command{SendAddrV2, WtxidRelay, VerAck}; !reflect.DeepEqual(expErr.Want, want) {
+		t.Errorf("unexpected wanted commands %v", expErr.Want)
+	}
+	var unexpected *message.ErrUnexpectedMessage
+	if !errors.As(err, &unexpected) || unexpected.Command != message.CmdPing {
+		t.Errorf("expected unexpected ping, got %+v", err)
+	}
+	want := "transcript diverged after version, sendaddrv2, expected sendaddrv2 or wtxidrelay or verack: unexpected ping message"
+	if err.Error() != want {
+		t.Errorf("unexpected error %q", err.Error())
+	}
+}
+
+func TestExpectationTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	go writeMessage(t, remote, testVersion())
+
+	_, err := handshakeExpectation.Within(50*time.Millisecond).Run(local, protocolVersion, common.SimNet)
+	var timeoutErr *message.ErrHandshakeTimeout
+	if !errors.As(err, &timeoutErr) || timeoutErr.Phase != message.CmdVerAck {
+		t.Fatalf("expected verack timeout, got %+v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timeout should wrap context.DeadlineExceeded")
+	}
+
+	// the interrupted read leaves the deadline for the caller to reset
+	if _, err := Expect(VerAck).Run(local, protocolVersion, common.SimNet); err == nil {
+		t.Fatalf("read should fail until the deadline is reset")
+	}
+	local.SetReadDeadline(time.Time{})
+	go writeMessage(t, remote, &message.MsgVerAck{})
+	if _, err := Expect(VerAck).Within(time.Second).Run(local, protocolVersion, common.SimNet); err != nil {
+		t.Errorf("connection should be usable after the timeout: %+v", err)
+	}
+}
+
+func TestExpectationImmutable(t *testing.T) {
+	base := Expect(Version)
+	a := base.Then(VerAck)
+	b := base.Then(Allow(SendAddrV2)).Within(time.Second)
+
+	if len(base.steps) != 1 || len(a.steps) != 2 || len(b.steps) != 2 {
+		t.Errorf("extending an expectation changed another one")
+	}
+	if a.timeout != 0 || b.timeout != time.Second {
+		t.Errorf("unexpected timeouts %v and %v", a.timeout, b.timeout)
+	}
+}
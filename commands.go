@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"handshake/checker"
+	"handshake/common"
+	"handshake/conformance"
+	"handshake/message"
+	"handshake/peer"
+	"handshake/scan"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// command describes a subcommand of the CLI.
+type command struct {
+	name  string
+	short string
+	long  string
+	data  interface{}
+}
+
+// commands are the subcommands of the CLI, in the order of the help text.
+var commands = []command{
+	{"handshake", "Perform a handshake with a node",
+		"Connects to the node, exchanges version and verack and prints what the node announced.",
+		&handshakeCmd{}},
+	{"ping", "Measure the round trip time to a node",
+		"Performs a handshake and measures the round trip time of ping/pong exchanges.",
+		&pingCmd{}},
+	{"listen", "Accept inbound connections",
+		"Listens for inbound connections and prints every peer completing the handshake.",
+		&listenCmd{}},
+	{"crawl", "Collect addresses announced by a node",
+		"Performs a handshake, asks for addresses with getaddr and prints the ones the node announces with addr or addrv2 messages.",
+		&crawlCmd{}},
+	{"decode", "Decode raw messages",
+		"Decodes hex encoded messages, including their header, and dumps them.",
+		&decodeCmd{}},
+	{"send", "Send a message to a node",
+		"Performs a handshake, sends a message with the given command and hex payload and prints the messages received afterwards.",
+		&sendCmd{}},
+	{"scan", "Handshake with many nodes",
+		"Reads addresses from a file or stdin, one per line, and handshakes with them concurrently.  A record per node is written to stdout as JSON lines or CSV and a summary to stderr at the end.",
+		&scanCmd{}},
+	{"conformance", "Check the protocol conformance of a node",
+		"Confronts the node with malformed and out of order messages and prints a pass/fail report.",
+		&conformanceCmd{}},
+}
+
+// interruptContext returns a context which is cancelled on SIGINT.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// handshake performs the handshake described by the options with addr.
+func handshake(ctx context.Context, addr string) (*peer.HandshakeResult, error) {
+	return peer.HandshakeContext(ctx, addr, cfg.network(), cfg.ProtocolVersion,
+		cfg.handshakeConfig())
+}
+
+// printResult prints what was negotiated with the remote peer.
+func printResult(res *peer.HandshakeResult) {
+	fmt.Printf("Remote peer %s (protocol %d, services %v, height %d)\n",
+		res.UserAgent, res.RemoteProtocolVersion, res.Services, res.StartHeight)
+	fmt.Printf("Negotiated version %d, features %+v, clock offset %s\n",
+		res.NegotiatedVersion, res.Features, res.ClockOffset)
+	fmt.Printf("Timings: dial %s, version %s, verack %s, total %s\n",
+		res.Timings.Dial, res.Timings.Version, res.Timings.VerAck, res.Timings.Total)
+}
+
+// handshakeCmd exchanges version and verack with a node.
+type handshakeCmd struct {
+	Args struct {
+		Address string `positional-arg-name:"address" description:"Node address, the port defaults to the network's default port"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute runs the command.
+func (c *handshakeCmd) Execute(args []string) error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	res, err := handshake(ctx, c.Args.Address)
+	if cfg.Output == outputJSON {
+		if err == nil {
+			res.Conn.Close()
+		}
+		if jsonErr := writeJSON(os.Stdout, newHandshakeReport(c.Args.Address, res, err)); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	defer res.Conn.Close()
+
+	fmt.Println("Handshake was successful!")
+	printResult(res)
+	return nil
+}
+
+// pingCmd measures round trip times after the handshake.
+type pingCmd struct {
+	Count    int           `short:"c" long:"count" default:"4" description:"Number of pings to send"`
+	Interval time.Duration `short:"i" long:"interval" default:"1s" description:"Time between pings"`
+	Timeout  time.Duration `long:"timeout" default:"5s" description:"Time to wait for each pong"`
+
+	Args struct {
+		Address string `positional-arg-name:"address" description:"Node address, the port defaults to the network's default port"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute runs the command.
+func (c *pingCmd) Execute(args []string) error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	res, err := handshake(ctx, c.Args.Address)
+	if err != nil {
+		return err
+	}
+	defer res.Conn.Close()
+
+	var count int
+	var min, total time.Duration
+	for i := 0; i < c.Count; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(c.Interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		pingCtx, cancelPing := context.WithTimeout(ctx, c.Timeout)
+		rtt, err := checker.MeasureRTTContext(pingCtx, res.Conn,
+			res.NegotiatedVersion, res.Network)
+		cancelPing()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Pong from %s: time=%v\n", res.Conn.RemoteAddr(), rtt)
+
+		count++
+		total += rtt
+		if min == 0 || rtt < min {
+			min = rtt
+		}
+	}
+
+	if count > 0 {
+		fmt.Printf("%d pongs received, min %v, avg %v\n", count, min,
+			total/time.Duration(count))
+	}
+	return nil
+}
+
+// listenCmd accepts inbound peers until interrupted.
+type listenCmd struct {
+	Listen string `short:"l" long:"listen" description:"Interface/port to listen on (default all interfaces on the network's default port)"`
+}
+
+// Execute runs the command.
+func (c *listenCmd) Execute(args []string) error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	addr := c.Listen
+	if addr == "" {
+		port := cfg.params().DefaultPort
+		if port == 0 {
+			return errors.New("no default port for the network, use --listen")
+		}
+		addr = net.JoinHostPort("", strconv.Itoa(int(port)))
+	}
+
+	server, err := peer.Listen(addr, cfg.network(), cfg.ProtocolVersion,
+		cfg.handshakeConfig())
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	fmt.Printf("Listening on %s\n", server.Addr())
+
+	for {
+		res, err := server.Accept()
+		var rejectErr *peer.RejectError
+		if errors.As(err, &rejectErr) {
+			fmt.Println(rejectErr.Error())
+			continue
+		} else if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		fmt.Printf("Accepted %s\n", res.Conn.RemoteAddr())
+		printResult(res)
+
+		// Keep the peer connected, answering its pings, until it leaves.
+		p := peer.NewPeer(res)
+		p.Start()
+		go func() {
+			select {
+			case <-p.Done():
+				fmt.Printf("Peer %s disconnected: %v\n",
+					res.Conn.RemoteAddr(), p.DisconnectReason())
+			case <-ctx.Done():
+				p.Disconnect()
+			}
+		}()
+	}
+}
+
+// crawlCmd collects the addresses a node announces.
+type crawlCmd struct {
+	Duration time.Duration `long:"duration" default:"30s" description:"Time to collect addresses for"`
+
+	Args struct {
+		Address string `positional-arg-name:"address" description:"Node address, the port defaults to the network's default port"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute runs the command.
+func (c *crawlCmd) Execute(args []string) error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	res, err := handshake(ctx, c.Args.Address)
+	if err != nil {
+		return err
+	}
+
+	// The handlers run on the read loop of the peer, which must not block
+	// on addrs once nobody reads it anymore.
+	addrs := make(chan *common.NetAddressV2, message.MaxAddrPerMsg)
+	stop := make(chan struct{})
+	defer close(stop)
+	forward := func(na *common.NetAddressV2) bool {
+		select {
+		case addrs <- na:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	p := peer.NewPeer(res)
+	p.Handle(message.CmdAddr, func(p *peer.Peer, msg message.Message) {
+		for _, na := range msg.(*message.MsgAddr).AddrList {
+			if !forward(common.NetAddressV2FromNetAddress(na)) {
+				return
+			}
+		}
+	})
+	p.Handle(message.CmdAddrV2, func(p *peer.Peer, msg message.Message) {
+		for _, na := range msg.(*message.MsgAddrV2).AddrList {
+			if !forward(na) {
+				return
+			}
+		}
+	})
+	p.Start()
+	defer p.Disconnect()
+
+	// Nodes answer getaddr once per connection, with addrv2 messages when
+	// it was negotiated.
+	p.QueueMessage(&message.MsgGetAddr{}, nil)
+
+	timer := time.NewTimer(c.Duration)
+	defer timer.Stop()
+
+	count := 0
+	for {
+		select {
+		case na := <-addrs:
+			count++
+			fmt.Printf("%s %v services %v last seen %v\n", na, na.NetworkID,
+				na.Services, na.Timestamp)
+		case <-timer.C:
+			fmt.Printf("%d addresses received\n", count)
+			return nil
+		case <-p.Done():
+			fmt.Printf("%d addresses received\n", count)
+			return p.DisconnectReason()
+		case <-ctx.Done():
+			fmt.Printf("%d addresses received\n", count)
+			return nil
+		}
+	}
+}
+
+// decodeCmd dumps hex encoded messages.
+type decodeCmd struct {
+	Args struct {
+		Hex []string `positional-arg-name:"hex" description:"Hex encoded messages, read from stdin when omitted"`
+	} `positional-args:"yes"`
+}
+
+// Execute runs the command.
+func (c *decodeCmd) Execute(args []string) error {
+	var input io.Reader = os.Stdin
+	if len(c.Args.Hex) > 0 {
+		input = strings.NewReader(strings.Join(c.Args.Hex, ""))
+	}
+
+	raw, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(strings.Join(strings.Fields(string(raw)), ""))
+	if err != nil {
+		return err
+	}
+
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		_, msg, _, err := message.ReadMessageWithEncodingN(r,
+			cfg.ProtocolVersion, cfg.network(), message.WitnessEncoding)
+		if err == message.ErrUnknownMessage {
+			fmt.Println("(unknown message skipped)")
+			continue
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s", msg.Command(), spew.Sdump(msg))
+	}
+	return nil
+}
+
+// rawMessage is a message with an arbitrary command and payload.
+type rawMessage struct {
+	command string
+	payload []byte
+}
+
+// BtcDecode is not supported, raw messages are only sent.
+func (msg *rawMessage) BtcDecode(r io.Reader, pver uint32, enc message.MessageEncoding) error {
+	return errors.New("raw messages can't be decoded")
+}
+
+// BtcEncode writes the payload as is.
+func (msg *rawMessage) BtcEncode(w io.Writer, pver uint32, enc message.MessageEncoding) error {
+	_, err := w.Write(msg.payload)
+	return err
+}
+
+// Command returns the command of the message.
+func (msg *rawMessage) Command() string {
+	return msg.command
+}
+
+// sendCmd sends a message after the handshake.
+type sendCmd struct {
+	Wait time.Duration `short:"w" long:"wait" default:"2s" description:"Time to print the messages received after sending"`
+
+	Args struct {
+		Address string `positional-arg-name:"address" description:"Node address, the port defaults to the network's default port"`
+		Command string `positional-arg-name:"command" description:"Command of the message"`
+		Payload string `positional-arg-name:"payload" description:"Hex encoded payload"`
+	} `positional-args:"yes"`
+}
+
+// Execute runs the command.
+func (c *sendCmd) Execute(args []string) error {
+	if c.Args.Address == "" || c.Args.Command == "" {
+		return errors.New("the address and command arguments are required")
+	}
+	payload, err := hex.DecodeString(c.Args.Payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	res, err := handshake(ctx, c.Args.Address)
+	if err != nil {
+		return err
+	}
+	defer res.Conn.Close()
+
+	msg := &rawMessage{command: c.Args.Command, payload: payload}
+	err = message.WriteMessageWithEncodingN(res.Conn, msg, res.NegotiatedVersion,
+		res.Network, message.WitnessEncoding)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sent %s message (%d bytes)\n", msg.command, len(payload))
+
+	res.Conn.SetReadDeadline(time.Now().Add(c.Wait))
+	for {
+		_, reply, _, err := message.ReadMessageWithEncodingN(res.Conn,
+			res.NegotiatedVersion, res.Network, message.WitnessEncoding)
+		if err == message.ErrUnknownMessage {
+			fmt.Println("Received unknown message")
+			continue
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("Received %s: %s", reply.Command(), spew.Sdump(reply))
+	}
+}
+
+// conformanceCmd runs the conformance scenarios against a node.
+type conformanceCmd struct {
+	Timeout time.Duration `long:"timeout" default:"2s" description:"Time to wait for the reaction to every scenario"`
+
+	Args struct {
+		Address string `positional-arg-name:"address" description:"Node address, the port defaults to the network's default port"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute runs the command.
+func (c *conformanceCmd) Execute(args []string) error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	hcfg := cfg.handshakeConfig()
+	hcfg.RetryPolicy = nil
+	ccfg := &conformance.Config{
+		Network:         cfg.network(),
+		ProtocolVersion: cfg.ProtocolVersion,
+		Timeout:         c.Timeout,
+		Dialer:          hcfg.Dialer,
+		Handshake:       hcfg,
+	}
+	report, err := conformance.Run(ctx, c.Args.Address, ccfg)
+	if err != nil {
+		return err
+	}
+
+	report.WriteText(os.Stdout)
+	if !report.Passed() {
+		return errors.New("node failed conformance scenarios")
+	}
+	return nil
+}
+
+// scanCmd handshakes with every address read from a file or stdin.
+type scanCmd struct {
+	File        string        `short:"f" long:"file" default:"-" description:"File with one address per line, - for stdin"`
+	Workers     int           `short:"w" long:"workers" default:"16" description:"Maximum number of concurrent handshakes"`
+	Rate        float64       `short:"r" long:"rate" default:"10" description:"Maximum number of handshakes started per second, 0 for no limit"`
+	Format      string        `long:"format" default:"jsonl" choice:"jsonl" choice:"csv" description:"Format of the records"`
+	PingTimeout time.Duration `long:"pingtimeout" default:"5s" description:"Time to wait for the pong measuring the round trip time"`
+}
+
+// Execute runs the command.
+func (c *scanCmd) Execute(args []string) error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	input := io.Reader(os.Stdin)
+	if c.File != "-" {
+		f, err := os.Open(c.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	var w scanWriter
+	switch c.Format {
+	case "csv":
+		w = newCSVScanWriter(os.Stdout)
+	default:
+		w = newJSONScanWriter(os.Stdout)
+	}
+
+	scfg := &scan.Config{
+		Network:         cfg.network(),
+		ProtocolVersion: cfg.ProtocolVersion,
+		Workers:         c.Workers,
+		Rate:            c.Rate,
+		PingTimeout:     c.PingTimeout,
+		Handshake:       cfg.handshakeConfig(),
+	}
+
+	addrs := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- scan.ReadAddresses(ctx, input, addrs)
+	}()
+
+	start := time.Now()
+	var summary scan.Summary
+	classes := make(map[errorClass]int)
+	var writeErr error
+	for res := range scan.Scan(ctx, addrs, scfg) {
+		summary.Add(&res)
+		if res.Err != nil {
+			classes[classifyError(res.Err)]++
+		}
+		if writeErr == nil {
+			writeErr = w.Write(&res)
+		}
+	}
+	if writeErr == nil {
+		writeErr = w.Flush()
+	}
+
+	writeScanSummary(os.Stderr, &summary, classes, time.Since(start))
+	if err := <-readErr; err != nil {
+		return err
+	}
+	return writeErr
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 	"io"
 	"net"
@@ -44,6 +45,12 @@ const (
 	// TestNet3 represents the test network (version 3).
 	TestNet3 BitcoinNet = 0x0709110b
 
+	// TestNet4 represents the test network (version 4).
+	TestNet4 BitcoinNet = 0x283f161c
+
+	// SigNet represents the default public signet (BIP325).
+	SigNet BitcoinNet = 0x40cf030a
+
 	// SimNet represents the simulation test network.
 	SimNet BitcoinNet = 0x12141c16
 )
@@ -179,3 +186,13 @@ func (l BinaryFreeList) PutUint64(w io.Writer, byteOrder binary.ByteOrder, val u
 
 	return err
 }
+
+// RandomUint64 returns a cryptographically random uint64 value, e.g. for the
+// nonces of version and ping messages.
+func RandomUint64() (uint64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
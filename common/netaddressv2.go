@@ -0,0 +1,181 @@
+package common
+
+import (
+	"encoding/base32"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// AddrV2NetworkID identifies the network of an address in the addrv2 message
+// (BIP155).
+type AddrV2NetworkID uint8
+
+const (
+	// NetIDIPv4 is an IPv4 address, 4 bytes.
+	NetIDIPv4 AddrV2NetworkID = 0x01
+
+	// NetIDIPv6 is an IPv6 address, 16 bytes.
+	NetIDIPv6 AddrV2NetworkID = 0x02
+
+	// NetIDTorV2 is a Tor v2 hidden service, 10 bytes.  Tor no longer
+	// supports it but the id stays reserved.
+	NetIDTorV2 AddrV2NetworkID = 0x03
+
+	// NetIDTorV3 is a Tor v3 hidden service public key, 32 bytes.
+	NetIDTorV3 AddrV2NetworkID = 0x04
+
+	// NetIDI2P is the SHA256 of an I2P destination, 32 bytes.
+	NetIDI2P AddrV2NetworkID = 0x05
+
+	// NetIDCJDNS is a CJDNS address, 16 bytes starting with 0xfc.
+	NetIDCJDNS AddrV2NetworkID = 0x06
+)
+
+// MaxAddrV2Size is the maximum size of an address in the addrv2 message,
+// whatever its network.
+const MaxAddrV2Size = 512
+
+// addrV2Sizes maps the known network ids to their fixed address size.
+var addrV2Sizes = map[AddrV2NetworkID]int{
+	NetIDIPv4:  net.IPv4len,
+	NetIDIPv6:  net.IPv6len,
+	NetIDTorV2: 10,
+	NetIDTorV3: 32,
+	NetIDI2P:   32,
+	NetIDCJDNS: net.IPv6len,
+}
+
+// AddrV2Size returns the fixed address size of a network id and whether the
+// network is known.
+func AddrV2Size(netID AddrV2NetworkID) (int, bool) {
+	size, ok := addrV2Sizes[netID]
+	return size, ok
+}
+
+// String returns the name of the network.
+func (id AddrV2NetworkID) String() string {
+	switch id {
+	case NetIDIPv4:
+		return "ipv4"
+	case NetIDIPv6:
+		return "ipv6"
+	case NetIDTorV2:
+		return "torv2"
+	case NetIDTorV3:
+		return "torv3"
+	case NetIDI2P:
+		return "i2p"
+	case NetIDCJDNS:
+		return "cjdns"
+	}
+	return fmt.Sprintf("AddrV2NetworkID(%d)", uint8(id))
+}
+
+// NetAddressV2 defines information about a peer on the network as carried by
+// the addrv2 message (BIP155).  Unlike NetAddress it can hold addresses which
+// are not IP addresses such as Tor v3, I2P and CJDNS.
+type NetAddressV2 struct {
+	// Last time the address was seen.  This is encoded as a uint32 on the
+	// wire and therefore is limited to 2106.
+	Timestamp time.Time
+
+	// Bitfield which identifies the services supported by the address.
+	// Unlike in NetAddress it is encoded as a variable length integer.
+	Services ServiceFlag
+
+	// NetworkID tells how Addr has to be interpreted.
+	NetworkID AddrV2NetworkID
+
+	// Addr is the raw address, its size depends on NetworkID.
+	Addr []byte
+
+	// Port the peer is using.  This is encoded in big endian on the wire.
+	Port uint16
+}
+
+// torV3Version is the version byte of Tor v3 onion addresses.
+const torV3Version = 0x03
+
+// base32Encoding is the unpadded base32 alphabet of Tor and I2P addresses
+// which are then lower cased.
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NetAddressV2FromNetAddress converts a legacy address into its addrv2 form.
+func NetAddressV2FromNetAddress(na *NetAddress) *NetAddressV2 {
+	netID, addr := NetIDIPv6, []byte(na.IP.To16())
+	if ip4 := na.IP.To4(); ip4 != nil {
+		netID, addr = NetIDIPv4, []byte(ip4)
+	}
+
+	return &NetAddressV2{
+		Timestamp: na.Timestamp,
+		Services:  na.Services,
+		NetworkID: netID,
+		Addr:      addr,
+		Port:      na.Port,
+	}
+}
+
+// ToLegacy converts the address into a NetAddress.  Only IPv4 and IPv6
+// addresses can be converted, nil is returned for every other network.
+func (na *NetAddressV2) ToLegacy() *NetAddress {
+	switch na.NetworkID {
+	case NetIDIPv4, NetIDIPv6:
+		if len(na.Addr) != addrV2Sizes[na.NetworkID] {
+			return nil
+		}
+		return &NetAddress{
+			Timestamp: na.Timestamp,
+			Services:  na.Services,
+			IP:        net.IP(na.Addr).To16(),
+			Port:      na.Port,
+		}
+	}
+	return nil
+}
+
+// Host returns the address in its usual text form: dotted or colon separated
+// for IP and CJDNS addresses, a .onion name for Tor v3 and a .b32.i2p name for
+// I2P.  Unknown networks are shown as hex.
+func (na *NetAddressV2) Host() string {
+	if size, ok := addrV2Sizes[na.NetworkID]; ok && len(na.Addr) != size {
+		return fmt.Sprintf("invalid-%s-%x", na.NetworkID, na.Addr)
+	}
+
+	switch na.NetworkID {
+	case NetIDIPv4, NetIDIPv6, NetIDCJDNS:
+		return net.IP(na.Addr).String()
+
+	case NetIDTorV3:
+		// onion_address = base32(PUBKEY | CHECKSUM | VERSION) + ".onion"
+		// CHECKSUM = H(".onion checksum" | PUBKEY | VERSION)[:2]
+		h := sha3.New256()
+		h.Write([]byte(".onion checksum"))
+		h.Write(na.Addr)
+		h.Write([]byte{torV3Version})
+		checksum := h.Sum(nil)[:2]
+
+		b := make([]byte, 0, len(na.Addr)+3)
+		b = append(b, na.Addr...)
+		b = append(b, checksum...)
+		b = append(b, torV3Version)
+		return strings.ToLower(base32Encoding.EncodeToString(b)) + ".onion"
+
+	case NetIDTorV2:
+		return strings.ToLower(base32Encoding.EncodeToString(na.Addr)) + ".onion"
+
+	case NetIDI2P:
+		return strings.ToLower(base32Encoding.EncodeToString(na.Addr)) + ".b32.i2p"
+	}
+
+	return fmt.Sprintf("%s-%x", na.NetworkID, na.Addr)
+}
+
+// String returns the address as host:port.
+func (na *NetAddressV2) String() string {
+	return net.JoinHostPort(na.Host(), fmt.Sprint(na.Port))
+}
@@ -0,0 +1,222 @@
+package common
+
+import (
+	"net"
+	"testing"
+)
+
+// TestIPTypes ensures the various functions which determine the type of an IP
+// address based on RFCs work as intended.
+func TestIPTypes(t *testing.T) {
+	type ipTest struct {
+		in       string
+		rfc1918  bool
+		rfc3849  bool
+		rfc3927  bool
+		rfc4193  bool
+		rfc4862  bool
+		rfc5737  bool
+		rfc6598  bool
+		local    bool
+		valid    bool
+		routable bool
+		netType  AddrV2NetworkID
+	}
+
+	tests := []ipTest{
+		{in: "10.255.255.255", rfc1918: true, valid: true, netType: NetIDIPv4},
+		{in: "192.168.0.1", rfc1918: true, valid: true, netType: NetIDIPv4},
+		{in: "172.31.255.1", rfc1918: true, valid: true, netType: NetIDIPv4},
+		{in: "172.32.1.1", valid: true, routable: true, netType: NetIDIPv4},
+		{in: "169.254.250.120", rfc3927: true, valid: true, netType: NetIDIPv4},
+		{in: "100.64.1.1", rfc6598: true, valid: true, netType: NetIDIPv4},
+		{in: "100.128.1.1", valid: true, routable: true, netType: NetIDIPv4},
+		{in: "192.0.2.7", rfc5737: true, valid: true, netType: NetIDIPv4},
+		{in: "198.51.100.7", rfc5737: true, valid: true, netType: NetIDIPv4},
+		{in: "203.0.113.7", rfc5737: true, valid: true, netType: NetIDIPv4},
+		{in: "127.0.0.1", local: true, valid: true, netType: NetIDIPv4},
+		{in: "0.1.2.3", local: true, valid: true, netType: NetIDIPv4},
+		{in: "0.0.0.0", local: true, netType: NetIDIPv4},
+		{in: "255.255.255.255", netType: NetIDIPv4},
+		{in: "224.0.0.1", valid: true, netType: NetIDIPv4},
+		{in: "8.8.8.8", valid: true, routable: true, netType: NetIDIPv4},
+		{in: "::1", local: true, valid: true, netType: NetIDIPv6},
+		{in: "::", netType: NetIDIPv6},
+		{in: "2001:db8::1", rfc3849: true, netType: NetIDIPv6},
+		{in: "fc00::1", rfc4193: true, valid: true, netType: NetIDIPv6},
+		{in: "fe80::1", rfc4862: true, valid: true, netType: NetIDIPv6},
+		{in: "fe80:0:0:1::1", valid: true, routable: true, netType: NetIDIPv6},
+		{in: "fd87:d87e:eb43::1", rfc4193: true, valid: true, routable: true, netType: NetIDTorV2},
+		{in: "2a01:4f8::1", valid: true, routable: true, netType: NetIDIPv6},
+	}
+
+	for _, test := range tests {
+		na := &NetAddress{IP: net.ParseIP(test.in)}
+		got := ipTest{
+			in:       test.in,
+			rfc1918:  na.IsRFC1918(),
+			rfc3849:  na.IsRFC3849(),
+			rfc3927:  na.IsRFC3927(),
+			rfc4193:  na.IsRFC4193(),
+			rfc4862:  na.IsRFC4862(),
+			rfc5737:  na.IsRFC5737(),
+			rfc6598:  na.IsRFC6598(),
+			local:    na.IsLocal(),
+			valid:    na.IsValid(),
+			routable: na.IsRoutable(),
+			netType:  na.NetworkType(),
+		}
+		if got != test {
+			t.Errorf("%s:\n got: %+v\nwant: %+v", test.in, got, test)
+		}
+	}
+}
+
+// TestGroupKey tests the GroupKey function to ensure it properly groups
+// various IP addresses.
+func TestGroupKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		expected string
+	}{
+		// Local addresses.
+		{name: "ipv4 localhost", ip: "127.0.0.1", expected: "local"},
+		{name: "ipv6 localhost", ip: "::1", expected: "local"},
+		{name: "ipv4 zero", ip: "0.0.0.0", expected: "local"},
+		{name: "ipv4 first octet zero", ip: "0.1.2.3", expected: "local"},
+
+		// Unroutable addresses.
+		{name: "ipv4 invalid bcast", ip: "255.255.255.255", expected: "unroutable"},
+		{name: "ipv4 rfc1918 10/8", ip: "10.1.2.3", expected: "unroutable"},
+		{name: "ipv4 rfc1918 172.16/12", ip: "172.16.1.2", expected: "unroutable"},
+		{name: "ipv4 rfc1918 192.168/16", ip: "192.168.1.2", expected: "unroutable"},
+		{name: "ipv6 rfc3849 2001:db8::/32", ip: "2001:db8::1234", expected: "unroutable"},
+		{name: "ipv4 rfc3927 169.254/16", ip: "169.254.1.2", expected: "unroutable"},
+		{name: "ipv6 rfc4193 fc00::/7", ip: "fc00::1234", expected: "unroutable"},
+		{name: "ipv6 rfc4862 fe80::/64", ip: "fe80::1234", expected: "unroutable"},
+		{name: "ipv4 rfc5737 192.0.2/24", ip: "192.0.2.1", expected: "unroutable"},
+		{name: "ipv4 rfc6598 100.64/10", ip: "100.64.1.2", expected: "unroutable"},
+
+		// IPv4 normal.
+		{name: "ipv4 normal class a", ip: "12.1.2.3", expected: "12.1.0.0"},
+		{name: "ipv4 normal class b", ip: "173.1.2.3", expected: "173.1.0.0"},
+		{name: "ipv4 normal class c", ip: "196.1.2.3", expected: "196.1.0.0"},
+
+		// IPv6/IPv4 translations.
+		{name: "ipv6 rfc3964 with ipv4 encap", ip: "2002:0c01:0203::", expected: "12.1.0.0"},
+		{name: "ipv6 rfc4380 toredo ipv4", ip: "2001:0:1234::f3fe:fdfc", expected: "12.1.0.0"},
+		{name: "ipv6 rfc6052 well-known prefix with ipv4", ip: "64:ff9b::0c01:0203", expected: "12.1.0.0"},
+		{name: "ipv6 rfc6145 translated ipv4", ip: "::ffff:0:0c01:0203", expected: "12.1.0.0"},
+
+		// Tor.
+		{name: "ipv6 tor onioncat", ip: "fd87:d87e:eb43:1234::5678", expected: "tor:1"},
+		{name: "ipv6 tor onioncat 2", ip: "fd87:d87e:eb43:1245::6789", expected: "tor:1"},
+		{name: "ipv6 tor onioncat 3", ip: "fd87:d87e:eb43:a345::6789", expected: "tor:10"},
+
+		// IPv6 normal.
+		{name: "ipv6 normal", ip: "2602:100::1", expected: "2602:100::"},
+		{name: "ipv6 normal same /32", ip: "2602:100:ffff::1", expected: "2602:100::"},
+		{name: "ipv6 hurricane electric", ip: "2001:470:1f10:a1::2", expected: "2001:470::"},
+	}
+
+	for i, test := range tests {
+		na := &NetAddress{IP: net.ParseIP(test.ip)}
+		if key := na.GroupKey(); key != test.expected {
+			t.Errorf("TestGroupKey #%d (%s): unexpected group key "+
+				"- got '%s', want '%s'", i, test.name, key,
+				test.expected)
+		}
+	}
+}
+
+// TestNetAddressV2Classification ensures addrv2 addresses of every network are
+// classified and grouped.
+func TestNetAddressV2Classification(t *testing.T) {
+	key := make([]byte, 32)
+	key[0] = 0xa7
+	cjdns := net.ParseIP("fc32:17ea:e415:c3bf:9808:149d:b5a2:c9aa")
+
+	tests := []struct {
+		name     string
+		na       NetAddressV2
+		routable bool
+		netType  AddrV2NetworkID
+		group    string
+	}{
+		{
+			name:     "ipv4",
+			na:       NetAddressV2{NetworkID: NetIDIPv4, Addr: []byte{12, 1, 2, 3}},
+			routable: true,
+			netType:  NetIDIPv4,
+			group:    "12.1.0.0",
+		},
+		{
+			name:    "ipv4 private",
+			na:      NetAddressV2{NetworkID: NetIDIPv4, Addr: []byte{10, 1, 2, 3}},
+			netType: NetIDIPv4,
+			group:   "unroutable",
+		},
+		{
+			name:     "ipv6 onioncat",
+			na:       NetAddressV2{NetworkID: NetIDIPv6, Addr: net.ParseIP("fd87:d87e:eb43:1234::5678")},
+			routable: true,
+			netType:  NetIDTorV2,
+			group:    "tor:1",
+		},
+		{
+			name:     "torv3",
+			na:       NetAddressV2{NetworkID: NetIDTorV3, Addr: key},
+			routable: true,
+			netType:  NetIDTorV3,
+			group:    "torv3:10",
+		},
+		{
+			name:     "i2p",
+			na:       NetAddressV2{NetworkID: NetIDI2P, Addr: key},
+			routable: true,
+			netType:  NetIDI2P,
+			group:    "i2p:10",
+		},
+		{
+			name:     "cjdns",
+			na:       NetAddressV2{NetworkID: NetIDCJDNS, Addr: cjdns},
+			routable: true,
+			netType:  NetIDCJDNS,
+			group:    "cjdns:3",
+		},
+		{
+			name:    "cjdns without prefix",
+			na:      NetAddressV2{NetworkID: NetIDCJDNS, Addr: net.ParseIP("2602:100::1")},
+			netType: NetIDCJDNS,
+			group:   "unroutable",
+		},
+		{
+			name:    "torv3 wrong size",
+			na:      NetAddressV2{NetworkID: NetIDTorV3, Addr: key[:10]},
+			netType: NetIDTorV3,
+			group:   "unroutable",
+		},
+		{
+			name:    "unknown network",
+			na:      NetAddressV2{NetworkID: 0x42, Addr: key},
+			netType: 0x42,
+			group:   "unroutable",
+		},
+	}
+
+	for _, test := range tests {
+		if routable := test.na.IsRoutable(); routable != test.routable {
+			t.Errorf("%s: routable got %v, want %v", test.name,
+				routable, test.routable)
+		}
+		if netType := test.na.NetworkType(); netType != test.netType {
+			t.Errorf("%s: network type got %v, want %v", test.name,
+				netType, test.netType)
+		}
+		if group := test.na.GroupKey(); group != test.group {
+			t.Errorf("%s: group got %q, want %q", test.name, group,
+				test.group)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package common
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// ErrDuplicateNet describes an error where the parameters for a Bitcoin
+// network could not be registered because the network magic or name is
+// already in use.
+var ErrDuplicateNet = errors.New("duplicate Bitcoin network")
+
+// Params defines a Bitcoin network by the parameters relevant to talking to
+// its peers.
+type Params struct {
+	// Name defines a human-readable identifier for the network.
+	Name string
+
+	// Net defines the magic bytes used to identify the network.
+	Net BitcoinNet
+
+	// DefaultPort defines the default peer-to-peer port for the network.
+	// Zero means there is none and addresses need a port.
+	DefaultPort uint16
+
+	// DNSSeeds defines a list of DNS seeds for the network that are used
+	// as one method to discover peers.
+	DNSSeeds []string
+
+	// GenesisHash is the hash of the first block in the chain.
+	GenesisHash chainhash.Hash
+
+	// MinProtocolVersion is the lowest protocol version a peer on the
+	// network may announce.  Zero leaves the minimum to the peer package.
+	MinProtocolVersion uint32
+
+	// AddressPrefix is the human-readable part of the bech32 segwit
+	// addresses on the network.
+	AddressPrefix string
+}
+
+// MainNetParams defines the network parameters for the main Bitcoin network.
+var MainNetParams = Params{
+	Name:        "mainnet",
+	Net:         MainNet,
+	DefaultPort: 8333,
+	DNSSeeds: []string{
+		"seed.bitcoin.sipa.be",
+		"dnsseed.bluematt.me",
+		"dnsseed.bitcoin.dashjr-list-of-p2p-nodes.us",
+		"seed.bitcoinstats.com",
+		"seed.bitcoin.jonasschnelli.ch",
+		"seed.btc.petertodd.net",
+		"seed.bitcoin.sprovoost.nl",
+		"dnsseed.emzy.de",
+		"seed.bitcoin.wiz.biz",
+	},
+	GenesisHash:        newHashFromStr("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"),
+	MinProtocolVersion: 209,
+	AddressPrefix:      "bc",
+}
+
+// TestNet3Params defines the network parameters for the test Bitcoin network
+// (version 3).
+var TestNet3Params = Params{
+	Name:        "testnet3",
+	Net:         TestNet3,
+	DefaultPort: 18333,
+	DNSSeeds: []string{
+		"testnet-seed.bitcoin.jonasschnelli.ch",
+		"seed.tbtc.petertodd.net",
+		"seed.testnet.bitcoin.sprovoost.nl",
+		"testnet-seed.bluematt.me",
+	},
+	GenesisHash:        newHashFromStr("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943"),
+	MinProtocolVersion: 209,
+	AddressPrefix:      "tb",
+}
+
+// TestNet4Params defines the network parameters for the test Bitcoin network
+// (version 4).  It was introduced long after version 70016, so older peers
+// can't be on it.
+var TestNet4Params = Params{
+	Name:        "testnet4",
+	Net:         TestNet4,
+	DefaultPort: 48333,
+	DNSSeeds: []string{
+		"seed.testnet4.bitcoin.sprovoost.nl",
+		"seed.testnet4.wiz.biz",
+	},
+	GenesisHash:        newHashFromStr("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043"),
+	MinProtocolVersion: 70016,
+	AddressPrefix:      "tb",
+}
+
+// SigNetParams defines the network parameters for the default public signet
+// (BIP325).  Signet was introduced along with version 70016.
+var SigNetParams = Params{
+	Name:        "signet",
+	Net:         SigNet,
+	DefaultPort: 38333,
+	DNSSeeds: []string{
+		"seed.signet.bitcoin.sprovoost.nl",
+		"seed.signet.achownodes.xyz",
+	},
+	GenesisHash:        newHashFromStr("00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6"),
+	MinProtocolVersion: 70016,
+	AddressPrefix:      "tb",
+}
+
+// RegressionNetParams defines the network parameters for the regression test
+// Bitcoin network.
+var RegressionNetParams = Params{
+	Name:               "regtest",
+	Net:                TestNet,
+	DefaultPort:        18444,
+	DNSSeeds:           []string{},
+	GenesisHash:        newHashFromStr("0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"),
+	MinProtocolVersion: 209,
+	AddressPrefix:      "bcrt",
+}
+
+// SimNetParams defines the network parameters for the simulation test Bitcoin
+// network.
+var SimNetParams = Params{
+	Name:               "simnet",
+	Net:                SimNet,
+	DefaultPort:        18555,
+	DNSSeeds:           []string{},
+	GenesisHash:        newHashFromStr("683e86bd5c6d110d91b94b97137ba6bfe02dbbdb8e3dff722a669b5d69d77af6"),
+	MinProtocolVersion: 209,
+	AddressPrefix:      "sb",
+}
+
+var (
+	registryMtx sync.RWMutex
+
+	// registeredNets maps each registered network to its parameters.
+	registeredNets = make(map[BitcoinNet]*Params)
+
+	// registeredNames maps the name of each registered network to its
+	// parameters.
+	registeredNames = make(map[string]*Params)
+
+	// registeredOrder lists the registered parameters in the order they
+	// were registered.
+	registeredOrder []*Params
+)
+
+// Register registers the network parameters for a Bitcoin network, making
+// them available to ParamsForNet and ParamsByName.  ErrDuplicateNet is
+// returned when the network magic or name is already registered.
+//
+// Network parameters should be registered into this package by a main package
+// as early as possible.  Then, library packages may look up networks or
+// network parameters based on inputs and work regardless of the network being
+// standard or not.
+func Register(params *Params) error {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+
+	if _, ok := registeredNets[params.Net]; ok {
+		return ErrDuplicateNet
+	}
+	if _, ok := registeredNames[params.Name]; ok {
+		return ErrDuplicateNet
+	}
+
+	registeredNets[params.Net] = params
+	registeredNames[params.Name] = params
+	registeredOrder = append(registeredOrder, params)
+	return nil
+}
+
+// mustRegister performs the same function as Register except it panics if
+// there is an error.  This should only be called from package init functions.
+func mustRegister(params *Params) {
+	if err := Register(params); err != nil {
+		panic("failed to register network: " + err.Error())
+	}
+}
+
+// ParamsForNet returns the parameters of the registered network with the given
+// magic and whether it is registered.
+func ParamsForNet(net BitcoinNet) (*Params, bool) {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+
+	params, ok := registeredNets[net]
+	return params, ok
+}
+
+// ParamsByName returns the parameters of the registered network with the
+// given name and whether it is registered.
+func ParamsByName(name string) (*Params, bool) {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+
+	params, ok := registeredNames[name]
+	return params, ok
+}
+
+// RegisteredParams returns the parameters of every registered network in the
+// order they were registered.
+func RegisteredParams() []*Params {
+	registryMtx.RLock()
+	defer registryMtx.RUnlock()
+
+	return append([]*Params(nil), registeredOrder...)
+}
+
+// signetPrefix prefixes the challenge script of a custom signet in the strings
+// accepted by ParseBitcoinNet.
+const signetPrefix = "signet:"
+
+// String returns the name of a registered network, or its magic in hex as
+// accepted by ParseBitcoinNet otherwise.
+func (n BitcoinNet) String() string {
+	if params, ok := ParamsForNet(n); ok {
+		return params.Name
+	}
+	return fmt.Sprintf("0x%08x", uint32(n))
+}
+
+// ParseBitcoinNet returns the network described by s, which is either
+//
+//   - the name of a registered network, e.g. "mainnet",
+//   - its magic as a 0x prefixed hex number, e.g. "0xd9b4bef9", or
+//   - "signet:" followed by the hex encoded challenge script of a signet.
+//
+// The magic is the number read in little endian from the message start bytes,
+// so "0xd9b4bef9" is the main network whose messages start with f9 be b4 d9.
+func ParseBitcoinNet(s string) (BitcoinNet, error) {
+	if params, ok := ParamsByName(s); ok {
+		return params.Net, nil
+	}
+
+	if challengeHex, ok := strings.CutPrefix(s, signetPrefix); ok {
+		challenge, err := parseSigNetChallenge(challengeHex)
+		if err != nil {
+			return 0, err
+		}
+		return SigNetMagic(challenge), nil
+	}
+
+	if magic, ok := strings.CutPrefix(strings.ToLower(s), "0x"); ok {
+		n, err := strconv.ParseUint(magic, 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid network magic %q", s)
+		}
+		return BitcoinNet(n), nil
+	}
+
+	var names []string
+	for _, params := range RegisteredParams() {
+		names = append(names, params.Name)
+	}
+	return 0, fmt.Errorf("unknown network %q, expected one of %s, a 0x "+
+		"prefixed magic or %s<challenge>", s, strings.Join(names, ", "),
+		signetPrefix)
+}
+
+// ParseParams returns the parameters of the network described by s as
+// accepted by ParseBitcoinNet.  Parameters of registered networks are looked
+// up, custom signets get the parameters of CustomSigNetParams and other
+// networks only their name and magic.  The returned parameters aren't
+// registered.
+func ParseParams(s string) (*Params, error) {
+	net, err := ParseBitcoinNet(s)
+	if err != nil {
+		return nil, err
+	}
+	if params, ok := ParamsForNet(net); ok {
+		return params, nil
+	}
+
+	if challengeHex, ok := strings.CutPrefix(s, signetPrefix); ok {
+		challenge, err := parseSigNetChallenge(challengeHex)
+		if err != nil {
+			return nil, err
+		}
+		return CustomSigNetParams(challenge), nil
+	}
+
+	return &Params{Name: s, Net: net}, nil
+}
+
+// SigNetMagic returns the magic of the signet with the given challenge script
+// (BIP325).  Like Bitcoin Core, the message start bytes are the first four
+// bytes of the double SHA-256 of the challenge serialized with its length.
+func SigNetMagic(challenge []byte) BitcoinNet {
+	buf := make([]byte, 0, 5+len(challenge))
+	switch n := len(challenge); {
+	case n < 0xfd:
+		buf = append(buf, uint8(n))
+	case n <= 0xffff:
+		buf = binary.LittleEndian.AppendUint16(append(buf, 0xfd), uint16(n))
+	default:
+		buf = binary.LittleEndian.AppendUint32(append(buf, 0xfe), uint32(n))
+	}
+	buf = append(buf, challenge...)
+
+	hash := chainhash.DoubleHashB(buf)
+	return BitcoinNet(binary.LittleEndian.Uint32(hash[:4]))
+}
+
+// CustomSigNetParams returns the parameters of the signet with the given
+// challenge script.  Signets share the genesis block and the default port,
+// so only the name and the magic differ from SigNetParams.  There are no DNS
+// seeds for custom signets.
+func CustomSigNetParams(challenge []byte) *Params {
+	params := SigNetParams
+	params.Name = signetPrefix + hex.EncodeToString(challenge)
+	params.Net = SigNetMagic(challenge)
+	params.DNSSeeds = []string{}
+	return &params
+}
+
+// parseSigNetChallenge decodes the hex encoded challenge script of a signet.
+func parseSigNetChallenge(challengeHex string) ([]byte, error) {
+	challenge, err := hex.DecodeString(challengeHex)
+	if err != nil || len(challenge) == 0 {
+		return nil, fmt.Errorf("invalid signet challenge %q", challengeHex)
+	}
+	return challenge, nil
+}
+
+// newHashFromStr converts the passed big-endian hex string into a
+// chainhash.Hash.  It only differs from the one available in chainhash in
+// that it panics on an error since it will only (and must only) be called
+// with hard-coded, and therefore known good, hashes.
+func newHashFromStr(hexStr string) chainhash.Hash {
+	hash, err := chainhash.NewHashFromStr(hexStr)
+	if err != nil {
+		panic(err)
+	}
+	return *hash
+}
+
+func init() {
+	// Register all default networks when the package is initialized.
+	mustRegister(&MainNetParams)
+	mustRegister(&TestNet3Params)
+	mustRegister(&TestNet4Params)
+	mustRegister(&SigNetParams)
+	mustRegister(&RegressionNetParams)
+	mustRegister(&SimNetParams)
+}
@@ -0,0 +1,173 @@
+package common
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// TestParamsMatchBtcd ensures the parameters of the networks btcd knows agree
+// with it.
+func TestParamsMatchBtcd(t *testing.T) {
+	tests := []struct {
+		params *Params
+		btcd   *chaincfg.Params
+	}{
+		{&MainNetParams, &chaincfg.MainNetParams},
+		{&TestNet3Params, &chaincfg.TestNet3Params},
+		{&SigNetParams, &chaincfg.SigNetParams},
+		{&RegressionNetParams, &chaincfg.RegressionNetParams},
+		{&SimNetParams, &chaincfg.SimNetParams},
+	}
+
+	for _, test := range tests {
+		name := test.params.Name
+		if uint32(test.params.Net) != uint32(test.btcd.Net) {
+			t.Errorf("%s: net %#x, btcd has %#x", name,
+				uint32(test.params.Net), uint32(test.btcd.Net))
+		}
+		if port := test.params.DefaultPort; test.btcd.DefaultPort != strconv.Itoa(int(port)) {
+			t.Errorf("%s: default port %d, btcd has %s", name, port,
+				test.btcd.DefaultPort)
+		}
+		if test.params.GenesisHash != *test.btcd.GenesisHash {
+			t.Errorf("%s: genesis hash %v, btcd has %v", name,
+				test.params.GenesisHash, test.btcd.GenesisHash)
+		}
+		if test.params.AddressPrefix != test.btcd.Bech32HRPSegwit {
+			t.Errorf("%s: address prefix %q, btcd has %q", name,
+				test.params.AddressPrefix, test.btcd.Bech32HRPSegwit)
+		}
+	}
+}
+
+// TestRegistry ensures the default networks are registered and duplicates are
+// rejected.
+func TestRegistry(t *testing.T) {
+	for _, net := range []BitcoinNet{MainNet, TestNet3, TestNet4, SigNet, TestNet, SimNet} {
+		params, ok := ParamsForNet(net)
+		if !ok || params.Net != net {
+			t.Fatalf("network %#x isn't registered", uint32(net))
+		}
+		byName, ok := ParamsByName(params.Name)
+		if !ok || byName != params {
+			t.Errorf("network %s isn't registered by name", params.Name)
+		}
+	}
+	if len(RegisteredParams()) != 6 {
+		t.Errorf("expected 6 registered networks, got %d", len(RegisteredParams()))
+	}
+
+	if _, ok := ParamsForNet(0xffffffff); ok {
+		t.Errorf("unexpected parameters for unknown network")
+	}
+
+	dup := MainNetParams
+	dup.Name = "other"
+	if err := Register(&dup); !errors.Is(err, ErrDuplicateNet) {
+		t.Errorf("duplicate magic: got %v, want %v", err, ErrDuplicateNet)
+	}
+	dup = MainNetParams
+	dup.Net = 0xffffffff
+	if err := Register(&dup); !errors.Is(err, ErrDuplicateNet) {
+		t.Errorf("duplicate name: got %v, want %v", err, ErrDuplicateNet)
+	}
+}
+
+// TestSigNetMagic ensures the magic of a signet is derived from its challenge
+// like Bitcoin Core does.
+func TestSigNetMagic(t *testing.T) {
+	if magic := SigNetMagic(chaincfg.DefaultSignetChallenge); magic != SigNet {
+		t.Errorf("default signet magic %#x, want %#x", uint32(magic),
+			uint32(SigNet))
+	}
+
+	params := CustomSigNetParams(chaincfg.DefaultSignetChallenge)
+	if params.Net != SigNet || params.DefaultPort != SigNetParams.DefaultPort {
+		t.Errorf("unexpected custom signet params %+v", params)
+	}
+
+	// btcd derives the magic the same way for any challenge.
+	challenge := []byte{0x51}
+	btcdParams := chaincfg.CustomSignetParams(challenge, nil)
+	if magic := SigNetMagic(challenge); uint32(magic) != uint32(btcdParams.Net) {
+		t.Errorf("signet magic %#x, btcd has %#x", uint32(magic),
+			uint32(btcdParams.Net))
+	}
+}
+
+// TestParseBitcoinNet ensures networks are parsed from names, magic and
+// signet challenges and printed back.
+func TestParseBitcoinNet(t *testing.T) {
+	defaultChallenge := hex.EncodeToString(chaincfg.DefaultSignetChallenge)
+	tests := []struct {
+		in     string
+		want   BitcoinNet
+		str    string
+		hasErr bool
+	}{
+		{in: "mainnet", want: MainNet, str: "mainnet"},
+		{in: "testnet4", want: TestNet4, str: "testnet4"},
+		{in: "signet", want: SigNet, str: "signet"},
+		{in: "0xd9b4bef9", want: MainNet, str: "mainnet"},
+		{in: "0X0000ABCD", want: 0xabcd, str: "0x0000abcd"},
+		{in: "signet:" + defaultChallenge, want: SigNet, str: "signet"},
+		{in: "main", hasErr: true},
+		{in: "0x", hasErr: true},
+		{in: "0x1ffffffff", hasErr: true},
+		{in: "signet:", hasErr: true},
+		{in: "signet:zz", hasErr: true},
+	}
+
+	for _, test := range tests {
+		net, err := ParseBitcoinNet(test.in)
+		if test.hasErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.in, net)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.in, err)
+			continue
+		}
+		if net != test.want {
+			t.Errorf("%q: got %#x, want %#x", test.in, uint32(net),
+				uint32(test.want))
+		}
+		if net.String() != test.str {
+			t.Errorf("%q: String got %q, want %q", test.in, net.String(),
+				test.str)
+		}
+	}
+}
+
+// TestParseParams ensures custom networks get parameters.
+func TestParseParams(t *testing.T) {
+	params, err := ParseParams("signet:51")
+	if err != nil {
+		t.Fatalf("ParseParams: %v", err)
+	}
+	if params.Name != "signet:51" || params.Net != SigNetMagic([]byte{0x51}) ||
+		params.DefaultPort != 38333 {
+
+		t.Errorf("unexpected custom signet params %+v", params)
+	}
+
+	params, err = ParseParams("0x0000abcd")
+	if err != nil {
+		t.Fatalf("ParseParams: %v", err)
+	}
+	if params.Net != 0xabcd || params.DefaultPort != 0 {
+		t.Errorf("unexpected custom network params %+v", params)
+	}
+
+	params, err = ParseParams("regtest")
+	if err != nil || params != &RegressionNetParams {
+		t.Errorf("expected the registered regtest params, got %+v %v",
+			params, err)
+	}
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SFNodeNetwork is a flag used to indicate a peer is a full node.
+	SFNodeNetwork ServiceFlag = 1 << 0
+
+	// SFNodeBloom is a flag used to indicate a peer supports bloom
+	// filtering (BIP111).
+	SFNodeBloom ServiceFlag = 1 << 2
+
+	// SFNodeWitness is a flag used to indicate a peer supports blocks
+	// and transactions including witness data (BIP144).
+	SFNodeWitness ServiceFlag = 1 << 3
+
+	// SFNodeCompactFilters is a flag used to indicate a peer serves
+	// compact block filters (BIP157).
+	SFNodeCompactFilters ServiceFlag = 1 << 6
+
+	// SFNodeNetworkLimited is a flag used to indicate a peer serves the
+	// last 288 blocks only, e.g. because it is pruned (BIP159).
+	SFNodeNetworkLimited ServiceFlag = 1 << 10
+
+	// SFNodeP2PV2 is a flag used to indicate a peer supports the v2
+	// encrypted transport protocol (BIP324).
+	SFNodeP2PV2 ServiceFlag = 1 << 11
+)
+
+// orderedSFStrings is an ordered list of service flags from lowest to highest
+// with the names used by String and ParseServiceFlags.
+var orderedSFStrings = []struct {
+	flag ServiceFlag
+	name string
+}{
+	{SFNodeNetwork, "network"},
+	{SFNodeBloom, "bloom"},
+	{SFNodeWitness, "witness"},
+	{SFNodeCompactFilters, "compact_filters"},
+	{SFNodeNetworkLimited, "network_limited"},
+	{SFNodeP2PV2, "p2p_v2"},
+}
+
+// String returns the ServiceFlag as a comma separated list of flag names,
+// e.g. "network,witness", as accepted by ParseServiceFlags.  Unknown flags
+// are appended in hex and no flags at all are "none".
+func (f ServiceFlag) String() string {
+	if f == 0 {
+		return "none"
+	}
+
+	var names []string
+	for _, sf := range orderedSFStrings {
+		if f&sf.flag == sf.flag {
+			names = append(names, sf.name)
+			f -= sf.flag
+		}
+	}
+
+	// Add any remaining flags which aren't accounted for as hex.
+	if f != 0 {
+		names = append(names, "0x"+strconv.FormatUint(uint64(f), 16))
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseServiceFlags parses a comma separated list of service flags as printed
+// by String, e.g. "network,witness".  The names are case insensitive and may
+// be given as in Bitcoin Core, e.g. "NODE_WITNESS".  Flags without a name are
+// given as numbers, and a single number is the whole bit field, e.g. "1033".
+func ParseServiceFlags(s string) (ServiceFlag, error) {
+	var flags ServiceFlag
+	for _, field := range strings.Split(s, ",") {
+		name := strings.ToLower(strings.TrimSpace(field))
+		name = strings.TrimPrefix(name, "node_")
+		if name == "none" {
+			continue
+		}
+
+		flag, ok := serviceFlagByName(name)
+		if !ok {
+			n, err := strconv.ParseUint(name, 0, 64)
+			if err != nil {
+				return 0, fmt.Errorf("unknown service flag %q", field)
+			}
+			flag = ServiceFlag(n)
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+// serviceFlagByName returns the flag with the given name.
+func serviceFlagByName(name string) (ServiceFlag, bool) {
+	for _, sf := range orderedSFStrings {
+		if sf.name == name {
+			return sf.flag, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,70 @@
+package common
+
+import "testing"
+
+// TestServiceFlagStringer tests the stringized output for service flag types.
+func TestServiceFlagStringer(t *testing.T) {
+	tests := []struct {
+		in   ServiceFlag
+		want string
+	}{
+		{0, "none"},
+		{SFNodeNetwork, "network"},
+		{SFNodeBloom, "bloom"},
+		{SFNodeWitness, "witness"},
+		{SFNodeCompactFilters, "compact_filters"},
+		{SFNodeNetworkLimited, "network_limited"},
+		{SFNodeP2PV2, "p2p_v2"},
+		{0xffffffff, "network,bloom,witness,compact_filters,network_limited,p2p_v2,0xfffff3b2"},
+		{SFNodeNetwork | SFNodeWitness | SFNodeNetworkLimited, "network,witness,network_limited"},
+	}
+
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result, test.want)
+		}
+	}
+}
+
+// TestParseServiceFlags tests parsing service flags from names and numbers.
+func TestParseServiceFlags(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   ServiceFlag
+		hasErr bool
+	}{
+		{in: "none", want: 0},
+		{in: "network,witness", want: SFNodeNetwork | SFNodeWitness},
+		{in: "NODE_NETWORK, NODE_WITNESS", want: SFNodeNetwork | SFNodeWitness},
+		{in: "Compact_Filters", want: SFNodeCompactFilters},
+		{in: "1033", want: SFNodeNetwork | SFNodeWitness | SFNodeNetworkLimited},
+		{in: "witness,0x10000", want: SFNodeWitness | 0x10000},
+		{in: "p2p_v2,network_limited", want: SFNodeP2PV2 | SFNodeNetworkLimited},
+		{in: "", hasErr: true},
+		{in: "network,", hasErr: true},
+		{in: "archive", hasErr: true},
+	}
+
+	for _, test := range tests {
+		flags, err := ParseServiceFlags(test.in)
+		if test.hasErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.in, flags)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.in, err)
+			continue
+		}
+		if flags != test.want {
+			t.Errorf("%q: got %v, want %v", test.in, flags, test.want)
+		}
+
+		// Every flag list parses back to the same flags.
+		if back, err := ParseServiceFlags(flags.String()); err != nil || back != flags {
+			t.Errorf("%q: round trip of %q got %v %v", test.in, flags, back, err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"handshake/common"
+	"handshake/peer"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/jessevdk/go-flags"
+)
+
+const (
+	defaultConfigFilename  = "handshake.conf"
+	defaultNetwork         = "mainnet"
+	defaultProtocolVersion = 70016
+	defaultLogLevel        = "info"
+	defaultOutput          = outputText
+)
+
+var (
+	defaultHomeDir    = btcutil.AppDataDir("handshake", false)
+	defaultConfigFile = filepath.Join(defaultHomeDir, defaultConfigFilename)
+)
+
+// config defines the options shared by every command.  They may be set in
+// the config file, the command line takes precedence.
+type config struct {
+	ConfigFile      string        `short:"C" long:"configfile" description:"Path to configuration file"`
+	Network         string        `short:"n" long:"network" description:"Network to use"`
+	ProtocolVersion uint32        `short:"p" long:"protocolversion" description:"Protocol version to announce"`
+	UserAgent       string        `long:"useragent" description:"User agent to announce"`
+	Services        string        `long:"services" description:"Service flags to announce, e.g. network,witness"`
+	RequireServices string        `long:"requireservices" description:"Service flags the node has to announce, e.g. network,witness"`
+	DialTimeout     time.Duration `long:"dialtimeout" description:"Time allowed to establish the connection, 0 for none"`
+	ReadTimeout     time.Duration `long:"readtimeout" description:"Time allowed for the remote handshake messages, 0 for none"`
+	WriteTimeout    time.Duration `long:"writetimeout" description:"Time allowed for each write of our handshake messages, not counting the wait for the remote peer, 0 for none"`
+	Retries         int           `long:"retries" description:"Maximum number of handshake attempts"`
+	Proxy           string        `long:"proxy" description:"Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
+	ProxyUser       string        `long:"proxyuser" description:"Username for proxy server"`
+	ProxyPass       string        `long:"proxypass" default-mask:"-" description:"Password for proxy server"`
+	TorIsolation    bool          `long:"torisolation" description:"Enable Tor stream isolation by randomizing user credentials for each connection"`
+	Output          string        `short:"o" long:"output" description:"Output format {text, json}, json is supported by the handshake command"`
+	DebugLevel      string        `short:"d" long:"debuglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
+
+	// Parsed options, set by validateConfig.
+	netParams        *common.Params
+	services         common.ServiceFlag
+	requiredServices common.ServiceFlag
+}
+
+// cfg holds the options once parsed.  Commands read it when executed.
+var cfg = defaultConfig()
+
+// defaultConfig returns the config with every option set to its default.
+func defaultConfig() *config {
+	return &config{
+		ConfigFile:      defaultConfigFile,
+		Network:         defaultNetwork,
+		ProtocolVersion: defaultProtocolVersion,
+		UserAgent:       peer.DefaultUserAgent,
+		Services:        common.ServiceFlag(0).String(),
+		RequireServices: common.ServiceFlag(0).String(),
+		DialTimeout:     peer.DefaultDialTimeout,
+		ReadTimeout:     peer.NegotiationTimeout,
+		WriteTimeout:    peer.DefaultWriteTimeout,
+		Retries:         peer.HandshakeRetries,
+		Output:          defaultOutput,
+		DebugLevel:      defaultLogLevel,
+	}
+}
+
+// newParser returns the command line parser for cfg with every command.
+func newParser(cfg *config) *flags.Parser {
+	parser := flags.NewParser(cfg, flags.HelpFlag|flags.PassDoubleDash)
+
+	// The networks come from the registry in common.
+	network := parser.FindOptionByLongName("network")
+	network.Description = fmt.Sprintf("%s {%s}, a 0x prefixed magic or "+
+		"signet:<challenge hex>", network.Description,
+		strings.Join(networkNames(), ", "))
+
+	for _, cmd := range commands {
+		parser.AddCommand(cmd.name, cmd.short, cmd.long, cmd.data)
+	}
+	return parser
+}
+
+// parseArgs loads the config file and parses args into cfg, the command line
+// taking precedence.  The chosen command is executed once every option is
+// set.
+//
+// The config file is read before the command line, so the command line is
+// pre-parsed to find it first.
+func parseArgs(cfg *config, args []string) error {
+	preCfg := struct {
+		ConfigFile string `short:"C" long:"configfile"`
+	}{ConfigFile: defaultConfigFile}
+	preParser := flags.NewParser(&preCfg, flags.IgnoreUnknown|flags.PassDoubleDash)
+	if _, err := preParser.ParseArgs(args); err != nil {
+		return err
+	}
+
+	parser := newParser(cfg)
+	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+		if cmd == nil {
+			return nil
+		}
+		if err := validateConfig(cfg, parser.Active.Name); err != nil {
+			return err
+		}
+		return cmd.Execute(args)
+	}
+
+	err := flags.NewIniParser(parser).ParseFile(preCfg.ConfigFile)
+	if err != nil {
+		var pathErr *os.PathError
+		if !errors.As(err, &pathErr) || preCfg.ConfigFile != defaultConfigFile {
+			return fmt.Errorf("error parsing config file: %w", err)
+		}
+		// A missing default config file is fine.
+	}
+
+	// Parse command line options again to ensure they take precedence.
+	_, err = parser.ParseArgs(args)
+	return err
+}
+
+// validateConfig checks the parsed options for the given command and applies
+// the log levels.
+func validateConfig(cfg *config, command string) error {
+	params, err := common.ParseParams(cfg.Network)
+	if err != nil {
+		return err
+	}
+
+	// Custom networks are registered so the peer package finds their
+	// default port and minimum protocol version.
+	if _, ok := common.ParamsForNet(params.Net); !ok {
+		if err := common.Register(params); err != nil {
+			return fmt.Errorf("can't use network %q: %w", cfg.Network, err)
+		}
+	}
+	cfg.netParams = params
+
+	cfg.services, err = common.ParseServiceFlags(cfg.Services)
+	if err != nil {
+		return fmt.Errorf("invalid --services: %w", err)
+	}
+	cfg.requiredServices, err = common.ParseServiceFlags(cfg.RequireServices)
+	if err != nil {
+		return fmt.Errorf("invalid --requireservices: %w", err)
+	}
+
+	if cfg.Output != outputText && cfg.Output != outputJSON {
+		return fmt.Errorf("unknown output format %q, expected %s or %s",
+			cfg.Output, outputText, outputJSON)
+	}
+	if cfg.Output == outputJSON && !jsonCommands[command] {
+		return fmt.Errorf("the %s command doesn't support --output %s",
+			command, outputJSON)
+	}
+
+	if cfg.DebugLevel == "show" {
+		fmt.Println("Supported subsystems", supportedSubsystems())
+		os.Exit(0)
+	}
+	return parseAndSetDebugLevels(cfg.DebugLevel)
+}
+
+// params returns the parameters of the configured network.
+func (cfg *config) params() *common.Params {
+	return cfg.netParams
+}
+
+// network returns the magic of the configured network.
+func (cfg *config) network() common.BitcoinNet {
+	return cfg.params().Net
+}
+
+// handshakeConfig returns the handshake configuration described by the
+// options.
+func (cfg *config) handshakeConfig() *peer.HandshakeConfig {
+	hcfg := peer.DefaultHandshakeConfig()
+	hcfg.UserAgent = cfg.UserAgent
+	hcfg.Services = cfg.services
+	hcfg.RequiredServices = cfg.requiredServices
+	hcfg.DialTimeout = cfg.DialTimeout
+	hcfg.ReadTimeout = cfg.ReadTimeout
+	hcfg.WriteTimeout = cfg.WriteTimeout
+
+	policy := peer.DefaultRetryPolicy()
+	policy.MaxAttempts = cfg.Retries
+	hcfg.RetryPolicy = policy
+	hcfg.OnAttempt = func(a peer.Attempt) {
+		if a.Err != nil && a.Retry {
+			log.Infof("Attempt %d failed, retrying in %v: %v", a.Number,
+				a.Delay, a.Err)
+		}
+	}
+
+	if cfg.Proxy != "" {
+		hcfg.Dialer = peer.NewSOCKS5Dialer(cfg.Proxy, cfg.ProxyUser,
+			cfg.ProxyPass, cfg.TorIsolation)
+	}
+
+	return hcfg
+}
+
+// networkNames returns the names accepted by --network.
+func networkNames() []string {
+	var names []string
+	for _, params := range common.RegisteredParams() {
+		names = append(names, params.Name)
+	}
+	return names
+}
@@ -0,0 +1,369 @@
+package conformance
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"handshake/common"
+	"handshake/message"
+	"handshake/peer"
+)
+
+// DefaultTimeout is how long we wait for the node to react to a probe.
+const DefaultTimeout = 2 * time.Second
+
+// Outcome is how the node reacted to a scenario.
+type Outcome int
+
+const (
+	// Ignored means the node neither answered nor disconnected and kept
+	// processing the messages following the probe.
+	Ignored Outcome = iota + 1
+
+	// Answered means the node replied to the probe, e.g. with a reject or
+	// a version message, and stayed connected.
+	Answered
+
+	// Disconnected means the node closed the connection.
+	Disconnected
+)
+
+// Map of outcomes back to their string for pretty printing.
+var outcomeStrings = map[Outcome]string{
+	Ignored:      "ignored",
+	Answered:     "answered",
+	Disconnected: "disconnected",
+}
+
+// String returns the Outcome in human-readable form.
+func (o Outcome) String() string {
+	if s, ok := outcomeStrings[o]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown Outcome (%d)", int(o))
+}
+
+// Config describes the node under test and how to talk to it.
+type Config struct {
+	// Network the node runs on.
+	Network common.BitcoinNet
+
+	// ProtocolVersion we announce in our version messages.
+	ProtocolVersion uint32
+
+	// Timeout bounds waiting for the reaction to every probe, including
+	// the handshake preceding it.  DefaultTimeout is used when it is zero.
+	Timeout time.Duration
+
+	// Dialer establishes the connections.  A plain net.Dialer is used when
+	// it is nil.
+	Dialer peer.Dialer
+
+	// Handshake configures the handshake preceding the probes which need
+	// one.  When it is nil, DefaultHandshakeConfig without retries is used.
+	// Its Dialer defaults to Dialer.
+	Handshake *peer.HandshakeConfig
+}
+
+// Result is the outcome of a single scenario.
+type Result struct {
+	Scenario *Scenario
+	Outcome  Outcome
+
+	// Commands received after the probe, in order.
+	Commands []string
+
+	// Pass tells whether the outcome is one the scenario accepts.
+	Pass bool
+
+	// Err is set when the scenario couldn't be run, e.g. because the
+	// connection couldn't be established.  The scenario fails then.
+	Err error
+}
+
+// Report is the result of running scenarios against a node.
+type Report struct {
+	Target  string
+	Results []Result
+}
+
+// Passed returns whether every scenario passed.
+func (r *Report) Passed() bool {
+	for _, res := range r.Results {
+		if !res.Pass {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteText writes a human-readable pass/fail table of the report to w.
+func (r *Report) WriteText(w io.Writer) error {
+	passed := 0
+	lines := []string{fmt.Sprintf("Conformance of %s", r.Target)}
+	for _, res := range r.Results {
+		status := "FAIL"
+		if res.Pass {
+			status = "PASS"
+			passed++
+		}
+
+		detail := res.Outcome.String()
+		if res.Err != nil {
+			detail = "error: " + res.Err.Error()
+		} else if len(res.Commands) > 0 {
+			detail += " (" + strings.Join(res.Commands, ", ") + ")"
+		}
+		lines = append(lines, fmt.Sprintf("%s  %-22s %s", status,
+			res.Scenario.Name, detail))
+	}
+	lines = append(lines, fmt.Sprintf("%d/%d scenarios passed", passed,
+		len(r.Results)))
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// Run runs every scenario of Scenarios against target, one connection each.
+func Run(ctx context.Context, target string, cfg *Config) (*Report, error) {
+	return RunScenarios(ctx, target, Scenarios(), cfg)
+}
+
+// RunScenarios runs the given scenarios against target in order.  It only
+// returns an error when ctx is done, failures of single scenarios are part of
+// the report.
+func RunScenarios(ctx context.Context, target string, scenarios []*Scenario, cfg *Config) (*Report, error) {
+	target, err := withDefaultPort(target, cfg.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Target: target}
+	for _, s := range scenarios {
+		res := runScenario(ctx, target, s, cfg)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Debugf("Scenario %s against %s: %v %v", s.Name, target,
+			res.Outcome, res.Commands)
+		report.Results = append(report.Results, res)
+	}
+
+	return report, nil
+}
+
+// runScenario connects to target, sends the probe of s and observes how the
+// node reacts.
+func runScenario(ctx context.Context, target string, s *Scenario, cfg *Config) Result {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*timeout)
+	defer cancel()
+
+	res := Result{Scenario: s}
+	c, err := connect(ctx, target, s.AfterHandshake, cfg)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer c.conn.Close()
+
+	// Interrupt the observation once ctx is done.
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+
+	probe, err := s.probe(c)
+	if err == nil {
+		_, err = c.conn.Write(probe)
+	}
+	if err == nil && s.Sync {
+		err = c.sync()
+	}
+	if err != nil {
+		if peer.IsDisconnect(err) {
+			res.Outcome = Disconnected
+			res.Pass = s.accepts(res.Outcome)
+		} else {
+			res.Err = err
+		}
+		return res
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	res.Outcome, res.Commands = c.observe(s.Sync)
+	res.Pass = s.accepts(res.Outcome)
+
+	return res
+}
+
+// answerCommands are the messages counted as an answer to a probe.  Anything
+// else a node sends, such as pings or inventory, is just recorded.
+var answerCommands = map[string]bool{
+	"reject":           true,
+	message.CmdVersion: true,
+	message.CmdVerAck:  true,
+}
+
+// conn is a connection to the node under test.
+type conn struct {
+	conn            net.Conn
+	network         common.BitcoinNet
+	protocolVersion uint32
+
+	// handshaked is set once version and verack were exchanged.
+	handshaked bool
+
+	// pingNonce identifies the pong answering our sync ping.
+	pingNonce uint64
+}
+
+// connect dials target, handshaking first when handshake is set.
+func connect(ctx context.Context, target string, handshake bool, cfg *Config) (*conn, error) {
+	c := &conn{network: cfg.Network, protocolVersion: cfg.ProtocolVersion}
+
+	if handshake {
+		var hcfg peer.HandshakeConfig
+		if cfg.Handshake != nil {
+			hcfg = *cfg.Handshake
+		} else {
+			hcfg = *peer.DefaultHandshakeConfig()
+			hcfg.RetryPolicy = nil
+		}
+		if hcfg.Dialer == nil {
+			hcfg.Dialer = cfg.Dialer
+		}
+		res, err := peer.HandshakeContext(ctx, target, cfg.Network, cfg.ProtocolVersion, &hcfg)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = res.Conn
+		c.protocolVersion = res.NegotiatedVersion
+		c.handshaked = true
+		return c, nil
+	}
+
+	var dialer peer.Dialer = &net.Dialer{}
+	if cfg.Dialer != nil {
+		dialer = cfg.Dialer
+	}
+	netConn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = netConn
+
+	return c, nil
+}
+
+// sync sends a message whose answer tells the node got past the probe: our
+// version before the handshake and a ping after it.
+func (c *conn) sync() error {
+	if !c.handshaked {
+		payload, err := c.version(c.protocolVersion)
+		if err != nil {
+			return err
+		}
+		return c.writeFrame(c.network, message.CmdVersion, payload)
+	}
+
+	nonce, err := common.RandomUint64()
+	if err != nil {
+		return err
+	}
+	c.pingNonce = nonce
+
+	payload := make([]byte, 8)
+	binary.LittleEndian.PutUint64(payload, nonce)
+	return c.writeFrame(c.network, message.CmdPing, payload)
+}
+
+// observe reads frames until the node disconnects, the read deadline passes
+// or, when synced, the sync message is answered.
+func (c *conn) observe(synced bool) (Outcome, []string) {
+	var commands []string
+	outcome := Ignored
+	for {
+		command, payload, err := readFrame(c.conn)
+		if peer.IsDisconnect(err) {
+			return Disconnected, commands
+		} else if err != nil {
+			// Nothing more within the deadline.
+			return outcome, commands
+		}
+		commands = append(commands, command)
+
+		if synced && c.answersSync(command, payload) {
+			return outcome, commands
+		}
+		if answerCommands[command] {
+			outcome = Answered
+		}
+	}
+}
+
+// answersSync reports whether the frame is the node's answer to our sync
+// message.
+func (c *conn) answersSync(command string, payload []byte) bool {
+	if !c.handshaked {
+		return command == message.CmdVersion
+	}
+	return command == message.CmdPong && len(payload) == 8 &&
+		binary.LittleEndian.Uint64(payload) == c.pingNonce
+}
+
+// version returns the payload of a version message announcing pver.
+func (c *conn) version(pver uint32) ([]byte, error) {
+	addrYou, _ := c.conn.RemoteAddr().(*net.TCPAddr)
+	if addrYou == nil {
+		addrYou = &net.TCPAddr{}
+	}
+
+	nonce, err := common.RandomUint64()
+	if err != nil {
+		return nil, err
+	}
+	msg := &message.MsgVersion{
+		ProtocolVersion: int32(pver),
+		Timestamp:       time.Unix(time.Now().Unix(), 0),
+		AddrYou: common.NetAddress{
+			Timestamp: time.Now(),
+			IP:        addrYou.IP,
+			Port:      uint16(addrYou.Port),
+		},
+		Nonce:     nonce,
+		UserAgent: peer.DefaultUserAgent,
+	}
+
+	return encode(msg, pver)
+}
+
+// writeFrame writes a well formed message with the given payload.
+func (c *conn) writeFrame(magic common.BitcoinNet, command string, payload []byte) error {
+	_, err := c.conn.Write(frame(magic, command, payload))
+	return err
+}
+
+// withDefaultPort appends the default port of network to target when it has
+// none.
+func withDefaultPort(target string, network common.BitcoinNet) (string, error) {
+	if _, _, err := net.SplitHostPort(target); err == nil {
+		return target, nil
+	}
+
+	params, ok := common.ParamsForNet(network)
+	if !ok || params.DefaultPort == 0 {
+		return "", fmt.Errorf("%w: no port in %q and no default port "+
+			"for network %v", peer.ErrInvalidAddress, target, network)
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(target, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(int(params.DefaultPort))), nil
+}
@@ -0,0 +1,183 @@
+package conformance
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"handshake/common"
+	"handshake/peer"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	btcdpeer "github.com/btcsuite/btcd/peer"
+)
+
+const protocolVersion = 70016
+
+// servePeers runs a simnet node built from our own server and peer, which
+// conforms to every scenario.
+func servePeers(t *testing.T) *peer.Server {
+	cfg := peer.DefaultHandshakeConfig()
+	cfg.AllowSelfConns = true
+	server, err := peer.Listen("127.0.0.1:0", common.SimNet, protocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+
+	go func() {
+		for {
+			res, err := server.Accept()
+			if err != nil {
+				if _, ok := err.(*peer.RejectError); ok {
+					continue
+				}
+				return
+			}
+			p := peer.NewPeer(res)
+			p.Start()
+			time.AfterFunc(5*time.Second, p.Disconnect)
+		}
+	}()
+
+	return server
+}
+
+func TestRun(t *testing.T) {
+	server := servePeers(t)
+	defer server.Close()
+
+	hcfg := peer.DefaultHandshakeConfig()
+	hcfg.RetryPolicy = nil
+	hcfg.AllowSelfConns = true
+	cfg := &Config{
+		Network:         common.SimNet,
+		ProtocolVersion: protocolVersion,
+		Timeout:         200 * time.Millisecond,
+		Handshake:       hcfg,
+	}
+	report, err := Run(context.Background(), server.Addr().String(), cfg)
+	if err != nil {
+		t.Fatalf("run failed: %+v", err)
+	}
+
+	want := map[string]Outcome{
+		"duplicate-version":     Ignored,
+		"verack-before-version": Disconnected,
+		"bad-checksum":          Disconnected,
+		"wrong-magic":           Disconnected,
+		"oversized-payload":     Disconnected,
+		"unknown-command":       Ignored,
+		"truncated-header":      Ignored,
+		"old-version-0":         Disconnected,
+		"old-version-106":       Disconnected,
+	}
+	if len(report.Results) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(report.Results))
+	}
+	for _, res := range report.Results {
+		if res.Err != nil || res.Outcome != want[res.Scenario.Name] || !res.Pass {
+			t.Errorf("%s: unexpected result %v %v %+v", res.Scenario.Name,
+				res.Outcome, res.Pass, res.Err)
+		}
+	}
+	if !report.Passed() {
+		t.Errorf("report should pass")
+	}
+
+	var text strings.Builder
+	if err := report.WriteText(&text); err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+	if !strings.Contains(text.String(), "9/9 scenarios passed") {
+		t.Errorf("unexpected report\n%s", text.String())
+	}
+}
+
+func TestRunUnreachable(t *testing.T) {
+	cfg := &Config{Network: common.SimNet, ProtocolVersion: protocolVersion, Timeout: 100 * time.Millisecond}
+	report, err := RunScenarios(context.Background(), "127.0.0.1:1", Scenarios()[:1], cfg)
+	if err != nil {
+		t.Fatalf("run failed: %+v", err)
+	}
+	if res := report.Results[0]; res.Err == nil || res.Pass {
+		t.Errorf("unreachable node should fail the scenario, got %+v", res)
+	}
+}
+
+// serveBtcd runs a simnet node built from btcd's peer package.
+func serveBtcd(t *testing.T, params *chaincfg.Params) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+
+	peerCfg := &btcdpeer.Config{
+		UserAgentName:    "btcd",
+		UserAgentVersion: "1.0.0",
+		ChainParams:      params,
+		AllowSelfConns:   true,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			p := btcdpeer.NewInboundPeer(peerCfg)
+			p.AssociateConnection(conn)
+		}
+	}()
+
+	return listener
+}
+
+// TestRunBtcd runs the scenarios against btcd.  On regtest btcd tolerates
+// malformed messages from localhost, which the suite has to catch.
+func TestRunBtcd(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  *chaincfg.Params
+		network common.BitcoinNet
+		failed  []string
+	}{
+		{"simnet", &chaincfg.SimNetParams, common.SimNet, nil},
+		{"regtest", &chaincfg.RegressionNetParams, common.TestNet, []string{"oversized-payload"}},
+	}
+
+	for _, test := range tests {
+		listener := serveBtcd(t, test.params)
+
+		hcfg := peer.DefaultHandshakeConfig()
+		hcfg.RetryPolicy = nil
+		hcfg.AllowSelfConns = true
+		cfg := &Config{
+			Network:         test.network,
+			ProtocolVersion: protocolVersion,
+			Timeout:         200 * time.Millisecond,
+			Handshake:       hcfg,
+		}
+		report, err := Run(context.Background(), listener.Addr().String(), cfg)
+		listener.Close()
+		if err != nil {
+			t.Fatalf("%s: run failed: %+v", test.name, err)
+		}
+
+		var failed []string
+		for _, res := range report.Results {
+			if res.Err != nil {
+				t.Errorf("%s: %s couldn't run: %+v", test.name, res.Scenario.Name, res.Err)
+			}
+			if !res.Pass {
+				failed = append(failed, res.Scenario.Name)
+			}
+		}
+		if strings.Join(failed, ",") != strings.Join(test.failed, ",") {
+			t.Errorf("%s: failed scenarios %v, want %v", test.name, failed, test.failed)
+		}
+		if report.Passed() != (len(test.failed) == 0) {
+			t.Errorf("%s: unexpected report outcome %v", test.name, report.Passed())
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"handshake/common"
+	"handshake/message"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// maxFramePayload bounds the payload of frames read from the node under test.
+const maxFramePayload = message.MaxMessagePayload
+
+// Scenario is a single misbehaviour we confront the node with.
+type Scenario struct {
+	// Name identifies the scenario in reports.
+	Name string
+
+	// Description tells what the scenario sends.
+	Description string
+
+	// AfterHandshake makes the scenario complete the handshake before
+	// sending the probe.
+	AfterHandshake bool
+
+	// Sync makes the scenario follow the probe with a message the node
+	// has to answer, our version before the handshake and a ping after
+	// it, so it is told apart from a node which ignored the probe.
+	Sync bool
+
+	// Accept lists the outcomes which pass the scenario.
+	Accept []Outcome
+
+	// probe returns the raw bytes sent to the node.
+	probe func(c *conn) ([]byte, error)
+}
+
+// accepts reports whether outcome passes the scenario.
+func (s *Scenario) accepts(outcome Outcome) bool {
+	for _, o := range s.Accept {
+		if o == outcome {
+			return true
+		}
+	}
+	return false
+}
+
+// Scenarios returns the scenarios run by Run, in order.
+func Scenarios() []*Scenario {
+	return []*Scenario{
+		{
+			Name:           "duplicate-version",
+			Description:    "a second version message after the handshake",
+			AfterHandshake: true,
+			Sync:           true,
+			Accept:         []Outcome{Ignored, Disconnected},
+			probe: func(c *conn) ([]byte, error) {
+				return versionFrame(c, c.network, c.protocolVersion)
+			},
+		},
+		{
+			Name:        "verack-before-version",
+			Description: "a verack message before any version",
+			Sync:        true,
+			Accept:      []Outcome{Ignored, Disconnected},
+			probe: func(c *conn) ([]byte, error) {
+				return frame(c.network, message.CmdVerAck, nil), nil
+			},
+		},
+		{
+			Name:           "bad-checksum",
+			Description:    "a ping whose header checksum doesn't match the payload",
+			AfterHandshake: true,
+			Sync:           true,
+			Accept:         []Outcome{Ignored, Disconnected},
+			probe: func(c *conn) ([]byte, error) {
+				b := frame(c.network, message.CmdPing, make([]byte, 8))
+				b[20] ^= 0xff
+				return b, nil
+			},
+		},
+		{
+			Name:        "wrong-magic",
+			Description: "a version message with the magic of another network",
+			Accept:      []Outcome{Disconnected},
+			probe: func(c *conn) ([]byte, error) {
+				magic := common.MainNet
+				if c.network == common.MainNet {
+					magic = common.TestNet3
+				}
+				return versionFrame(c, magic, c.protocolVersion)
+			},
+		},
+		{
+			Name:           "oversized-payload",
+			Description:    "a header announcing a payload above the 32MB limit",
+			AfterHandshake: true,
+			Accept:         []Outcome{Disconnected},
+			probe: func(c *conn) ([]byte, error) {
+				b := frame(c.network, message.CmdPing, nil)
+				binary.LittleEndian.PutUint32(b[16:20], message.MaxMessagePayload+1)
+				return b, nil
+			},
+		},
+		{
+			Name:           "unknown-command",
+			Description:    "a well formed message with an unknown command",
+			AfterHandshake: true,
+			Sync:           true,
+			Accept:         []Outcome{Ignored},
+			probe: func(c *conn) ([]byte, error) {
+				return frame(c.network, "conformance", []byte{1, 2, 3}), nil
+			},
+		},
+		{
+			Name:           "truncated-header",
+			Description:    "the first 10 bytes of a message header",
+			AfterHandshake: true,
+			Accept:         []Outcome{Ignored, Disconnected},
+			probe: func(c *conn) ([]byte, error) {
+				return frame(c.network, message.CmdPing, make([]byte, 8))[:10], nil
+			},
+		},
+		oldVersion(0),
+		oldVersion(message.AddrMeVersion),
+	}
+}
+
+// oldVersion returns the scenario announcing protocol version pver, which is
+// below what any node accepts.
+func oldVersion(pver uint32) *Scenario {
+	return &Scenario{
+		Name:        fmt.Sprintf("old-version-%d", pver),
+		Description: fmt.Sprintf("a version message announcing protocol version %d", pver),
+		Accept:      []Outcome{Disconnected},
+		probe: func(c *conn) ([]byte, error) {
+			return versionFrame(c, c.network, pver)
+		},
+	}
+}
+
+// versionFrame returns a version message announcing pver with the given magic.
+func versionFrame(c *conn, magic common.BitcoinNet, pver uint32) ([]byte, error) {
+	payload, err := c.version(pver)
+	if err != nil {
+		return nil, err
+	}
+	return frame(magic, message.CmdVersion, payload), nil
+}
+
+// encode returns the payload of msg encoded for pver.
+func encode(msg message.Message, pver uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, pver, message.WitnessEncoding)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// frame returns a message with a valid header for payload.  Scenarios corrupt
+// the header afterwards as they need.
+func frame(magic common.BitcoinNet, command string, payload []byte) []byte {
+	b := make([]byte, message.MessageHeaderSize, message.MessageHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(b[0:4], uint32(magic))
+	copy(b[4:4+message.CommandSize], command)
+	binary.LittleEndian.PutUint32(b[16:20], uint32(len(payload)))
+	copy(b[20:24], chainhash.DoubleHashB(payload)[:4])
+	return append(b, payload...)
+}
+
+// readFrame reads a message without decoding it, so any command the node sends
+// is seen.
+func readFrame(r io.Reader) (string, []byte, error) {
+	var hdr [message.MessageHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", nil, err
+	}
+
+	command := string(bytes.TrimRight(hdr[4:4+message.CommandSize], "\x00"))
+	length := binary.LittleEndian.Uint32(hdr[16:20])
+	if length > maxFramePayload {
+		return "", nil, fmt.Errorf("%w - %s message of %d bytes",
+			message.ErrPayloadTooLarge, command, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", nil, err
+	}
+	return command, payload, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// regtestVerAck is a verack message on the regression test network.
+const regtestVerAck = "fabfb5da76657261636b000000000000000000005df6e0e2"
+
+// TestParseArgsConfigFile ensures options are read from the config file and
+// the command line takes precedence.
+func TestParseArgsConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "handshake.conf")
+	conf := "network=simnet\nretries=5\n"
+	if err := os.WriteFile(path, []byte(conf), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// The commands read the package config.
+	defer func(orig *config) { cfg = orig }(cfg)
+	cfg = defaultConfig()
+
+	args := []string{"-C", path, "-n", "regtest", "decode", regtestVerAck}
+	if err := parseArgs(cfg, args); err != nil {
+		t.Fatalf("parseArgs: %v", err)
+	}
+	if cfg.Network != "regtest" {
+		t.Errorf("network: got %q, want %q", cfg.Network, "regtest")
+	}
+	if cfg.Retries != 5 {
+		t.Errorf("retries: got %d, want %d", cfg.Retries, 5)
+	}
+}
+
+// TestParseArgsErrors ensures invalid options and missing config files are
+// rejected.
+func TestParseArgsErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.conf")
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			"unknown network",
+			[]string{"-n", "foo", "decode", regtestVerAck},
+			"unknown network",
+		},
+		{
+			"missing config file",
+			[]string{"-C", missing, "decode", regtestVerAck},
+			"error parsing config file",
+		},
+		{
+			"json output",
+			[]string{"-o", "json", "decode", regtestVerAck},
+			"doesn't support --output json",
+		},
+		{
+			"invalid debug level",
+			[]string{"-d", "loud", "decode", regtestVerAck},
+			"debug level",
+		},
+	}
+
+	for _, test := range tests {
+		err := parseArgs(defaultConfig(), test.args)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err,
+				test.want)
+		}
+	}
+}
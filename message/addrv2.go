@@ -0,0 +1,199 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"handshake/common"
+)
+
+const CmdAddrV2 = "addrv2"
+
+// MaxAddrPerMsg is the maximum number of addresses that can be in a single
+// bitcoin addr or addrv2 message.
+const MaxAddrPerMsg = 1000
+
+// MsgAddrV2 implements the Message interface and represents a bitcoin addrv2
+// message (BIP155).  It is only sent to peers which announced sendaddrv2
+// during the handshake and can carry Tor v3, I2P and CJDNS addresses on top of
+// IP addresses.
+type MsgAddrV2 struct {
+	AddrList []*common.NetAddressV2
+}
+
+// AddAddress adds a known active peer to the message.
+func (msg *MsgAddrV2) AddAddress(na *common.NetAddressV2) error {
+	if len(msg.AddrList)+1 > MaxAddrPerMsg {
+		str := fmt.Sprintf("too many addresses in message [max %v]",
+			MaxAddrPerMsg)
+		return errors.New(str)
+	}
+
+	msg.AddrList = append(msg.AddrList, na)
+	return nil
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// Addresses of unknown networks are skipped as BIP155 requires, while an
+// address whose size doesn't match its network is an error.
+//
+// This is part of the Message interface implementation.
+func (msg *MsgAddrV2) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Limit to max addresses per message.
+	if count > MaxAddrPerMsg {
+		str := fmt.Sprintf("too many addresses for message "+
+			"[count %v, max %v]", count, MaxAddrPerMsg)
+		return errors.New(str)
+	}
+
+	msg.AddrList = make([]*common.NetAddressV2, 0, count)
+	for i := uint64(0); i < count; i++ {
+		na := &common.NetAddressV2{}
+		known, err := readNetAddressV2(r, pver, na)
+		if err != nil {
+			return err
+		}
+		if known {
+			msg.AddrList = append(msg.AddrList, na)
+		}
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgAddrV2) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	count := len(msg.AddrList)
+	if count > MaxAddrPerMsg {
+		str := fmt.Sprintf("too many addresses for message "+
+			"[count %v, max %v]", count, MaxAddrPerMsg)
+		return errors.New(str)
+	}
+
+	buf := binarySerializer.Borrow()
+	defer binarySerializer.Return(buf)
+
+	err := WriteVarIntBuf(w, pver, uint64(count), buf)
+	if err != nil {
+		return err
+	}
+
+	for _, na := range msg.AddrList {
+		err = writeNetAddressV2(w, pver, na, buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgAddrV2) Command() string {
+	return CmdAddrV2
+}
+
+// writeNetAddressV2 serializes a NetAddressV2 to w.
+func writeNetAddressV2(w io.Writer, pver uint32, na *common.NetAddressV2, buf []byte) error {
+	if len(na.Addr) > common.MaxAddrV2Size {
+		str := fmt.Sprintf("address too long [len %v, max %v]",
+			len(na.Addr), common.MaxAddrV2Size)
+		return errors.New(str)
+	}
+
+	// A zero time would wrap around, so an unknown time is 0.
+	var timestamp uint32
+	if !na.Timestamp.IsZero() {
+		timestamp = uint32(na.Timestamp.Unix())
+	}
+	binary.LittleEndian.PutUint32(buf[:4], timestamp)
+	if _, err := w.Write(buf[:4]); err != nil {
+		return err
+	}
+
+	err := WriteVarIntBuf(w, pver, uint64(na.Services), buf)
+	if err != nil {
+		return err
+	}
+
+	buf[0] = uint8(na.NetworkID)
+	if _, err := w.Write(buf[:1]); err != nil {
+		return err
+	}
+
+	err = WriteVarIntBuf(w, pver, uint64(len(na.Addr)), buf)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(na.Addr); err != nil {
+		return err
+	}
+
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	binary.BigEndian.PutUint16(buf[:2], na.Port)
+	_, err = w.Write(buf[:2])
+
+	return err
+}
+
+// readNetAddressV2 reads a NetAddressV2 from r and reports whether its network
+// is known.  Addresses of unknown networks are read but should be ignored.
+func readNetAddressV2(r io.Reader, pver uint32, na *common.NetAddressV2) (bool, error) {
+	timestamp, err := binarySerializer.Uint32(r, binary.LittleEndian)
+	if err != nil {
+		return false, err
+	}
+	na.Timestamp = time.Unix(int64(timestamp), 0)
+
+	services, err := ReadVarInt(r, pver)
+	if err != nil {
+		return false, err
+	}
+	na.Services = common.ServiceFlag(services)
+
+	networkID, err := binarySerializer.Uint8(r)
+	if err != nil {
+		return false, err
+	}
+	na.NetworkID = common.AddrV2NetworkID(networkID)
+
+	size, err := ReadVarInt(r, pver)
+	if err != nil {
+		return false, err
+	}
+	if size > common.MaxAddrV2Size {
+		str := fmt.Sprintf("address too long [len %v, max %v]",
+			size, common.MaxAddrV2Size)
+		return false, errors.New(str)
+	}
+
+	expected, known := common.AddrV2Size(na.NetworkID)
+	if known && int(size) != expected {
+		str := fmt.Sprintf("invalid %v address size %v, expected %v",
+			na.NetworkID, size, expected)
+		return false, errors.New(str)
+	}
+
+	na.Addr = make([]byte, size)
+	if _, err := io.ReadFull(r, na.Addr); err != nil {
+		return false, err
+	}
+
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	na.Port, err = binarySerializer.Uint16(r, binary.BigEndian)
+	if err != nil {
+		return false, err
+	}
+
+	return known, nil
+}
@@ -0,0 +1,93 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned while framing and decoding messages and negotiating a
+// connection.  They are usually wrapped with details, so compare them with
+// errors.Is.
+var (
+	// ErrUnknownMessage is the error returned when decoding an unknown
+	// message.
+	ErrUnknownMessage = errors.New("received unknown message")
+
+	// ErrBadMagic is returned for a message of another bitcoin network.
+	ErrBadMagic = errors.New("message from other network")
+
+	// ErrChecksumMismatch is returned when the payload doesn't match the
+	// checksum in the message header.
+	ErrChecksumMismatch = errors.New("payload checksum failed")
+
+	// ErrPayloadTooLarge is returned when the message header announces a
+	// payload above MaxMessagePayload.
+	ErrPayloadTooLarge = errors.New("message payload is too large")
+
+	// ErrVersionTooOld is returned when the remote peer announces a
+	// protocol version below the accepted minimum.
+	ErrVersionTooOld = errors.New("protocol version is too old")
+
+	// ErrSelfConnection is returned when the remote version carries a
+	// nonce we sent ourselves, so we are connected to ourselves.
+	ErrSelfConnection = errors.New("disconnecting peer connected to self")
+
+	// ErrMissingServices is returned when the remote peer doesn't
+	// advertise all of the services we require.
+	ErrMissingServices = errors.New("peer doesn't advertise the required services")
+)
+
+// Handshake phases reported by ErrHandshakeTimeout.
+const (
+	PhaseDial    = "dial"
+	PhaseVersion = "version"
+	PhaseVerAck  = "verack"
+)
+
+// PhasePong is reported by ErrHandshakeTimeout when a ping sent after the
+// handshake isn't answered in time.
+const PhasePong = "pong"
+
+// ErrUnexpectedMessage is returned when the remote peer sends a message which
+// is not allowed at that point of the conversation.
+type ErrUnexpectedMessage struct {
+	// Command is the command of the offending message.
+	Command string
+}
+
+// Error returns the error as a human-readable string.
+func (e *ErrUnexpectedMessage) Error() string {
+	return fmt.Sprintf("unexpected %s message", e.Command)
+}
+
+// ErrHandshakeTimeout is returned when the remote peer doesn't answer in time,
+// either during the handshake or, with PhasePong, to a ping sent after it.
+// Phase tells what we were waiting for and Err, when set, is the underlying
+// network error.
+type ErrHandshakeTimeout struct {
+	Phase string
+	Err   error
+}
+
+// Error returns the error as a human-readable string.
+func (e *ErrHandshakeTimeout) Error() string {
+	str := fmt.Sprintf("%s message not received in time", e.Phase)
+	if e.Phase == PhaseDial {
+		str = "dial timed out"
+	}
+	if e.Err != nil {
+		str += ": " + e.Err.Error()
+	}
+	return str
+}
+
+// Unwrap returns the underlying network error.
+func (e *ErrHandshakeTimeout) Unwrap() error {
+	return e.Err
+}
+
+// Timeout always reports true so the error can be handled like a net.Error
+// timeout.
+func (e *ErrHandshakeTimeout) Timeout() bool {
+	return true
+}
@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
+	"time"
+	"unicode/utf8"
 
 	"handshake/common"
 
@@ -16,6 +19,7 @@ import (
 type MessageEncoding uint32
 
 type Message interface {
+	BtcDecode(io.Reader, uint32, MessageEncoding) error
 	BtcEncode(io.Writer, uint32, MessageEncoding) error
 	Command() string
 }
@@ -44,9 +48,182 @@ const (
 	// checksum 4 bytes.
 	MessageHeaderSize = 24
 
+	// MaxMessagePayload is the maximum bytes a message can be regardless of other
+	// individual limits imposed by messages themselves.
+	MaxMessagePayload = (1024 * 1024 * 32) // 32MB
+
 	binaryFreeListMaxItems = 1024
 )
 
+// makeEmptyMessage creates a message of the appropriate concrete type based
+// on the command.
+func makeEmptyMessage(command string) (Message, error) {
+	var msg Message
+	switch command {
+	case CmdVersion:
+		msg = &MsgVersion{}
+
+	case CmdVerAck:
+		msg = &MsgVerAck{}
+
+	case CmdSendAddrV2:
+		msg = &MsgSendAddrV2{}
+
+	case CmdWtxidRelay:
+		msg = &MsgWtxidRelay{}
+
+	case CmdAddr:
+		msg = &MsgAddr{}
+
+	case CmdGetAddr:
+		msg = &MsgGetAddr{}
+
+	case CmdAddrV2:
+		msg = &MsgAddrV2{}
+
+	case CmdPing:
+		msg = &MsgPing{}
+
+	case CmdPong:
+		msg = &MsgPong{}
+
+	case CmdSendHeaders:
+		msg = &MsgSendHeaders{}
+
+	case CmdSendCmpct:
+		msg = &MsgSendCmpct{}
+
+	case CmdFeeFilter:
+		msg = &MsgFeeFilter{}
+
+	default:
+		return nil, ErrUnknownMessage
+	}
+	return msg, nil
+}
+
+// readMessageHeader reads a bitcoin message header from r.
+func readMessageHeader(r io.Reader) (int, *messageHeader, error) {
+	// Since readElements doesn't return the amount of bytes read, attempt
+	// to read the entire header into a buffer first in case there is a
+	// short read so the proper amount of read bytes are known.  This works
+	// since the header is a fixed size.
+	var headerBytes [MessageHeaderSize]byte
+	n, err := io.ReadFull(r, headerBytes[:])
+	if err != nil {
+		return n, nil, err
+	}
+	hr := bytes.NewReader(headerBytes[:])
+
+	// Create and populate a messageHeader struct from the raw header bytes.
+	hdr := messageHeader{}
+	var command [CommandSize]byte
+	readElements(hr, &hdr.magic, &command, &hdr.length, &hdr.checksum)
+
+	// Strip trailing zeros from command string.
+	hdr.command = string(bytes.TrimRight(command[:], "\x00"))
+
+	return n, &hdr, nil
+}
+
+// discardInput reads n bytes from reader r in chunks and discards the read
+// bytes.  This is used to skip payloads when various errors occur and helps
+// prevent rogue nodes from causing massive memory allocation through forging
+// header length.
+func discardInput(r io.Reader, n uint32) {
+	maxSize := uint32(10 * 1024) // 10k at a time
+	numReads := n / maxSize
+	bytesRemaining := n % maxSize
+	if n > 0 {
+		buf := make([]byte, maxSize)
+		for i := uint32(0); i < numReads; i++ {
+			io.ReadFull(r, buf)
+		}
+	}
+	if bytesRemaining > 0 {
+		buf := make([]byte, bytesRemaining)
+		io.ReadFull(r, buf)
+	}
+}
+
+// ReadMessageWithEncodingN reads, validates, and parses the next bitcoin Message
+// from r for the provided protocol version and bitcoin network.  It returns the
+// number of bytes read in addition to the parsed Message and raw bytes which
+// comprise the message.  It is the counterpart of WriteMessageWithEncodingN.
+func ReadMessageWithEncodingN(r io.Reader, pver uint32, btcnet common.BitcoinNet,
+	enc MessageEncoding) (int, Message, []byte, error) {
+
+	totalBytes := 0
+	n, hdr, err := readMessageHeader(r)
+	totalBytes += n
+	if err != nil {
+		return totalBytes, nil, nil, err
+	}
+
+	// Enforce maximum message payload.
+	if hdr.length > MaxMessagePayload {
+		err := fmt.Errorf("%w - header indicates %d bytes, but max "+
+			"message payload is %d bytes.", ErrPayloadTooLarge,
+			hdr.length, MaxMessagePayload)
+		return totalBytes, nil, nil, err
+	}
+
+	// Check for messages from the wrong bitcoin network.
+	if hdr.magic != btcnet {
+		discardInput(r, hdr.length)
+		err := fmt.Errorf("%w [%v]", ErrBadMagic, hdr.magic)
+		return totalBytes, nil, nil, err
+	}
+
+	// Check for malformed commands.
+	command := hdr.command
+	if !utf8.ValidString(command) {
+		discardInput(r, hdr.length)
+		str := fmt.Sprintf("invalid command %v", []byte(command))
+		return totalBytes, nil, nil, errors.New(str)
+	}
+
+	// Create struct of appropriate message type based on the command.
+	msg, err := makeEmptyMessage(command)
+	if err != nil {
+		// makeEmptyMessage can only return ErrUnknownMessage and it is
+		// important that we bubble it up to the caller.
+		log.Tracef("Skipping unknown %q message (%d bytes)", command,
+			hdr.length)
+		discardInput(r, hdr.length)
+		return totalBytes, nil, nil, err
+	}
+
+	// Read payload.
+	payload := make([]byte, hdr.length)
+	n, err = io.ReadFull(r, payload)
+	totalBytes += n
+	if err != nil {
+		return totalBytes, nil, nil, err
+	}
+
+	// Test checksum.
+	checksum := chainhash.DoubleHashB(payload)[0:4]
+	if !bytes.Equal(checksum, hdr.checksum[:]) {
+		err := fmt.Errorf("%w - header indicates %v, but actual "+
+			"checksum is %v.", ErrChecksumMismatch, hdr.checksum,
+			checksum)
+		return totalBytes, nil, nil, err
+	}
+	log.Tracef("Received %s message (%d bytes, checksum %x)", command,
+		hdr.length, hdr.checksum)
+
+	// Unmarshal message.  NOTE: This must be a *bytes.Buffer since the
+	// MsgVersion BtcDecode function requires it.
+	pr := bytes.NewBuffer(payload)
+	err = msg.BtcDecode(pr, pver, enc)
+	if err != nil {
+		return totalBytes, nil, nil, err
+	}
+
+	return totalBytes, msg, payload, nil
+}
+
 func WriteMessageWithEncodingN(w io.Writer, msg Message, pver uint32,
 	btcnet common.BitcoinNet, encoding MessageEncoding) error {
 
@@ -76,6 +253,9 @@ func WriteMessageWithEncodingN(w io.Writer, msg Message, pver uint32,
 	hdr.length = uint32(lenp)
 	copy(hdr.checksum[:], chainhash.DoubleHashB(payload)[0:4])
 
+	log.Tracef("Sending %s message (%d bytes, checksum %x)", cmd, lenp,
+		hdr.checksum)
+
 	// Encode the header for the message.  This is done to a buffer
 	// rather than directly to the writer since writeElements doesn't
 	// return the number of bytes written.
@@ -223,7 +403,299 @@ func writeElements(w io.Writer, elements ...interface{}) error {
 	return nil
 }
 
+// readElement reads the next sequence of bytes from r using little endian
+// depending on the concrete type of element pointed to.
+func readElement(r io.Reader, element interface{}) error {
+	// Attempt to read the element based on the concrete type via fast
+	// type assertions first.
+	switch e := element.(type) {
+	case *int32:
+		rv, err := binarySerializer.Uint32(r, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		*e = int32(rv)
+		return nil
+
+	case *uint32:
+		rv, err := binarySerializer.Uint32(r, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		*e = rv
+		return nil
+
+	case *int64:
+		rv, err := binarySerializer.Uint64(r, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		*e = int64(rv)
+		return nil
+
+	case *uint64:
+		rv, err := binarySerializer.Uint64(r, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		*e = rv
+		return nil
+
+	case *bool:
+		rv, err := binarySerializer.Uint8(r)
+		if err != nil {
+			return err
+		}
+		if rv == 0x00 {
+			*e = false
+		} else {
+			*e = true
+		}
+		return nil
+
+	// Unix timestamp encoded as an int64.
+	case *time.Time:
+		rv, err := binarySerializer.Uint64(r, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		*e = time.Unix(int64(rv), 0)
+		return nil
+
+	// Message header checksum.
+	case *[4]byte:
+		_, err := io.ReadFull(r, e[:])
+		if err != nil {
+			return err
+		}
+		return nil
+
+	// Message header command.
+	case *[CommandSize]uint8:
+		_, err := io.ReadFull(r, e[:])
+		if err != nil {
+			return err
+		}
+		return nil
+
+	// IP address.
+	case *[16]byte:
+		_, err := io.ReadFull(r, e[:])
+		if err != nil {
+			return err
+		}
+		return nil
+
+	case *chainhash.Hash:
+		_, err := io.ReadFull(r, e[:])
+		if err != nil {
+			return err
+		}
+		return nil
+
+	case *common.ServiceFlag:
+		rv, err := binarySerializer.Uint64(r, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		*e = common.ServiceFlag(rv)
+		return nil
+
+	case *common.InvType:
+		rv, err := binarySerializer.Uint32(r, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		*e = common.InvType(rv)
+		return nil
+
+	case *common.BitcoinNet:
+		rv, err := binarySerializer.Uint32(r, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		*e = common.BitcoinNet(rv)
+		return nil
+
+	case *common.BloomUpdateType:
+		rv, err := binarySerializer.Uint8(r)
+		if err != nil {
+			return err
+		}
+		*e = common.BloomUpdateType(rv)
+		return nil
+
+	case *common.RejectCode:
+		rv, err := binarySerializer.Uint8(r)
+		if err != nil {
+			return err
+		}
+		*e = common.RejectCode(rv)
+		return nil
+	}
+	return binary.Read(r, binary.LittleEndian, element)
+}
+
+// readElements reads multiple items from r.  It is equivalent to multiple
+// calls to readElement.
+func readElements(r io.Reader, elements ...interface{}) error {
+	for _, element := range elements {
+		err := readElement(r, element)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readNetAddress reads an encoded NetAddress from r depending on the protocol
+// version and whether or not the timestamp is included per ts.
+func readNetAddress(r io.Reader, pver uint32, na *common.NetAddress, ts bool) error {
+	var timestamp time.Time
+
+	// NOTE: The bitcoin protocol uses a uint32 for the timestamp so it will
+	// stop working somewhere around 2106.  Also timestamp wasn't added until
+	// protocol version >= NetAddressTimeVersion
+	if ts && pver >= NetAddressTimeVersion {
+		rv, err := binarySerializer.Uint32(r, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		timestamp = time.Unix(int64(rv), 0)
+	}
+
+	services, err := binarySerializer.Uint64(r, binary.LittleEndian)
+	if err != nil {
+		return err
+	}
+
+	var ip [16]byte
+	if _, err := io.ReadFull(r, ip[:]); err != nil {
+		return err
+	}
+
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	port, err := binarySerializer.Uint16(r, binary.BigEndian)
+	if err != nil {
+		return err
+	}
+
+	*na = common.NetAddress{
+		Timestamp: timestamp,
+		Services:  common.ServiceFlag(services),
+		IP:        net.IP(ip[:]),
+		Port:      port,
+	}
+	return nil
+}
+
+// ReadVarIntBuf reads a variable length integer from r using a preallocated
+// scratch buffer and returns it as a uint64.
+func ReadVarIntBuf(r io.Reader, pver uint32, buf []byte) (uint64, error) {
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return 0, err
+	}
+	discriminant := buf[0]
+
+	var rv, min uint64
+	switch discriminant {
+	case 0xff:
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, err
+		}
+		rv = binary.LittleEndian.Uint64(buf)
+		min = uint64(0x100000000)
+
+	case 0xfe:
+		if _, err := io.ReadFull(r, buf[:4]); err != nil {
+			return 0, err
+		}
+		rv = uint64(binary.LittleEndian.Uint32(buf[:4]))
+		min = uint64(0x10000)
+
+	case 0xfd:
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			return 0, err
+		}
+		rv = uint64(binary.LittleEndian.Uint16(buf[:2]))
+		min = uint64(0xfd)
+
+	default:
+		return uint64(discriminant), nil
+	}
+
+	// The encoding is not canonical if the value could have been encoded
+	// using fewer bytes.
+	if rv < min {
+		str := fmt.Sprintf("non-canonical varint %x - discriminant %x "+
+			"must encode a value greater than %x", rv, discriminant, min)
+		return 0, errors.New(str)
+	}
+
+	return rv, nil
+}
+
+// ReadVarInt reads a variable length integer from r and returns it as a uint64.
+func ReadVarInt(r io.Reader, pver uint32) (uint64, error) {
+	buf := binarySerializer.Borrow()
+	defer binarySerializer.Return(buf)
+
+	return ReadVarIntBuf(r, pver, buf)
+}
+
+func readVarStringBuf(r io.Reader, pver uint32, buf []byte) (string, error) {
+	count, err := ReadVarIntBuf(r, pver, buf)
+	if err != nil {
+		return "", err
+	}
+
+	// Prevent variable length strings that are larger than the maximum
+	// message size.  It would be possible to cause memory exhaustion and
+	// panics without a sane upper bound on this count.
+	if count > MaxMessagePayload {
+		str := fmt.Sprintf("variable length string is too long "+
+			"[count %d, max %d]", count, MaxMessagePayload)
+		return "", errors.New(str)
+	}
+
+	str := make([]byte, count)
+	_, err = io.ReadFull(r, str)
+	if err != nil {
+		return "", err
+	}
+	return string(str), nil
+}
+
+// ReadVarString reads a variable length string from r and returns it as a Go
+// string.  A variable length string is encoded as a variable length integer
+// containing the length of the string followed by the bytes that represent the
+// string itself.
+func ReadVarString(r io.Reader, pver uint32) (string, error) {
+	buf := binarySerializer.Borrow()
+	defer binarySerializer.Return(buf)
+
+	return readVarStringBuf(r, pver, buf)
+}
+
+// writeNetAddressBuf serializes a NetAddress to w depending on the protocol
+// version and whether or not the timestamp is included per ts using a
+// preallocated scratch buffer.
 func writeNetAddressBuf(w io.Writer, pver uint32, na *common.NetAddress, ts bool, buf []byte) error {
+	// NOTE: The bitcoin protocol uses a uint32 for the timestamp so it will
+	// stop working somewhere around 2106.  Also timestamp wasn't added
+	// until protocol version >= NetAddressTimeVersion.
+	if ts && pver >= NetAddressTimeVersion {
+		// A zero time would wrap around, so an unknown time is 0.
+		var timestamp uint32
+		if !na.Timestamp.IsZero() {
+			timestamp = uint32(na.Timestamp.Unix())
+		}
+		binary.LittleEndian.PutUint32(buf[:4], timestamp)
+		if _, err := w.Write(buf[:4]); err != nil {
+			return err
+		}
+	}
+
 	binary.LittleEndian.PutUint64(buf, uint64(na.Services))
 	if _, err := w.Write(buf); err != nil {
 		return err
@@ -0,0 +1,617 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"handshake/common"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btclog"
+)
+
+const testProtocolVersion = 70016
+
+func testVersion() *MsgVersion {
+	return &MsgVersion{
+		ProtocolVersion: testProtocolVersion,
+		Services:        1,
+		Timestamp:       time.Unix(0x495fab29, 0),
+		AddrYou: common.NetAddress{
+			IP:   net.ParseIP("192.168.0.1").To16(),
+			Port: 8333,
+		},
+		AddrMe: common.NetAddress{
+			Services: 1,
+			IP:       net.ParseIP("127.0.0.1").To16(),
+			Port:     8333,
+		},
+		Nonce:          123123,
+		UserAgent:      "/handshake:0.1.0/",
+		LastBlock:      234234,
+		DisableRelayTx: false,
+	}
+}
+
+func TestVersionRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	msg := testVersion()
+	err := WriteMessageWithEncodingN(&buf, msg, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+
+	n, decoded, payload, err := ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+	if n != MessageHeaderSize+len(payload) {
+		t.Errorf("unexpected byte count %d", n)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("decoded message mismatch\n got: %+v\nwant: %+v", decoded, msg)
+	}
+}
+
+func TestReadWireVersion(t *testing.T) {
+	me := wire.NewNetAddressIPPort(net.ParseIP("127.0.0.1"), 18555, wire.SFNodeNetwork)
+	you := wire.NewNetAddressIPPort(net.ParseIP("10.0.0.1"), 18555, 0)
+	wireMsg := wire.NewMsgVersion(me, you, 987, 100)
+	wireMsg.UserAgent = "/btcwire:0.5.0/"
+
+	var buf bytes.Buffer
+	err := wire.WriteMessage(&buf, wireMsg, testProtocolVersion, wire.SimNet)
+	if err != nil {
+		t.Fatalf("wire write failed: %+v", err)
+	}
+
+	_, decoded, _, err := ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+	msg, ok := decoded.(*MsgVersion)
+	if !ok {
+		t.Fatalf("expected *MsgVersion, got %T", decoded)
+	}
+	if msg.Nonce != 987 || msg.LastBlock != 100 || msg.UserAgent != wireMsg.UserAgent {
+		t.Errorf("unexpected decoded version %+v", msg)
+	}
+	if !msg.AddrMe.IP.Equal(me.IP) || msg.AddrMe.Port != me.Port {
+		t.Errorf("unexpected local address %+v", msg.AddrMe)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	var good bytes.Buffer
+	err := WriteMessageWithEncodingN(&good, &MsgVerAck{}, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+
+	badChecksum := append([]byte{}, good.Bytes()...)
+	badChecksum = append(badChecksum[:20], 0, 0, 0, 0)
+
+	oversized := append([]byte{}, good.Bytes()...)
+	binary.LittleEndian.PutUint32(oversized[16:20], MaxMessagePayload+1)
+
+	tests := []struct {
+		name    string
+		network common.BitcoinNet
+		data    []byte
+		want    error
+	}{
+		{"wrong network", common.MainNet, good.Bytes(), ErrBadMagic},
+		{"bad checksum", common.SimNet, badChecksum, ErrChecksumMismatch},
+		{"oversized payload", common.SimNet, oversized, ErrPayloadTooLarge},
+		{"truncated header", common.SimNet, good.Bytes()[:10], io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		r := bytes.NewReader(test.data)
+		_, _, _, err := ReadMessageWithEncodingN(r, testProtocolVersion, test.network, WitnessEncoding)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestReadUnknownMessage(t *testing.T) {
+	var buf bytes.Buffer
+	err := wire.WriteMessage(&buf, wire.NewMsgMemPool(), testProtocolVersion, wire.SimNet)
+	if err != nil {
+		t.Fatalf("wire write failed: %+v", err)
+	}
+	err = WriteMessageWithEncodingN(&buf, &MsgVerAck{}, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+
+	_, _, _, err = ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != ErrUnknownMessage {
+		t.Fatalf("expected ErrUnknownMessage, got %v", err)
+	}
+
+	// The unknown payload must have been discarded so the next message is
+	// still readable.
+	_, msg, _, err := ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+	if _, ok := msg.(*MsgVerAck); !ok {
+		t.Errorf("expected *MsgVerAck, got %T", msg)
+	}
+}
+
+func TestAddrV2RoundTrip(t *testing.T) {
+	torKey := bytes.Repeat([]byte{0xab}, 32)
+	i2pHash := bytes.Repeat([]byte{0x01}, 32)
+	cjdns := net.ParseIP("fc00::1")
+
+	msg := &MsgAddrV2{}
+	addrs := []*common.NetAddressV2{
+		{Timestamp: time.Unix(0x495fab29, 0), Services: 1, NetworkID: common.NetIDIPv4, Addr: net.ParseIP("10.0.0.1").To4(), Port: 8333},
+		{Timestamp: time.Unix(0x495fab29, 0), Services: 1033, NetworkID: common.NetIDIPv6, Addr: net.ParseIP("2001:db8::1"), Port: 8333},
+		{Timestamp: time.Unix(0x495fab29, 0), NetworkID: common.NetIDTorV3, Addr: torKey, Port: 8333},
+		{Timestamp: time.Unix(0x495fab29, 0), NetworkID: common.NetIDI2P, Addr: i2pHash, Port: 0},
+		{Timestamp: time.Unix(0x495fab29, 0), NetworkID: common.NetIDCJDNS, Addr: cjdns, Port: 8333},
+	}
+	for _, na := range addrs {
+		if err := msg.AddAddress(na); err != nil {
+			t.Fatalf("couldn't add address: %+v", err)
+		}
+	}
+
+	// addresses of unknown networks are skipped when decoding
+	unknown := &common.NetAddressV2{NetworkID: 0x42, Addr: []byte{1, 2, 3}}
+	msg.AddrList = append(msg.AddrList, unknown)
+
+	var buf bytes.Buffer
+	err := WriteMessageWithEncodingN(&buf, msg, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+
+	_, decoded, _, err := ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+	want := &MsgAddrV2{AddrList: addrs}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded message mismatch\n got: %+v\nwant: %+v", decoded, want)
+	}
+
+	hosts := []string{"10.0.0.1", "2001:db8::1", "", "aeaqcaibaeaqcaibaeaqcaibaeaqcaibaeaqcaibaeaqcaibaeaq.b32.i2p", "fc00::1"}
+	for i, host := range hosts {
+		if host != "" && addrs[i].Host() != host {
+			t.Errorf("unexpected host %s, want %s", addrs[i].Host(), host)
+		}
+	}
+}
+
+func TestReadWireAddrV2(t *testing.T) {
+	torKey := bytes.Repeat([]byte{0xab}, 32)
+	wireMsg := wire.NewMsgAddrV2()
+	wireMsg.AddrList = append(wireMsg.AddrList,
+		wire.NetAddressV2FromBytes(time.Unix(0x495fab29, 0), wire.SFNodeNetwork, torKey, 8333),
+		wire.NetAddressV2FromBytes(time.Unix(0x495fab29, 0), wire.SFNodeNetwork, net.ParseIP("10.0.0.1").To4(), 8333),
+	)
+
+	var buf bytes.Buffer
+	err := wire.WriteMessage(&buf, wireMsg, testProtocolVersion, wire.SimNet)
+	if err != nil {
+		t.Fatalf("wire write failed: %+v", err)
+	}
+
+	_, decoded, _, err := ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+	msg := decoded.(*MsgAddrV2)
+	if len(msg.AddrList) != len(wireMsg.AddrList) {
+		t.Fatalf("expected %d addresses, got %d", len(wireMsg.AddrList), len(msg.AddrList))
+	}
+	for i, na := range msg.AddrList {
+		if na.Host() != wireMsg.AddrList[i].Addr.String() {
+			t.Errorf("address %d: got %s, want %s", i, na.Host(), wireMsg.AddrList[i].Addr)
+		}
+	}
+	if msg.AddrList[0].NetworkID != common.NetIDTorV3 {
+		t.Errorf("unexpected network %v", msg.AddrList[0].NetworkID)
+	}
+}
+
+func TestAddrV2Limits(t *testing.T) {
+	msg := &MsgAddrV2{}
+	na := &common.NetAddressV2{NetworkID: common.NetIDIPv4, Addr: []byte{1, 2, 3, 4}}
+	for i := 0; i < MaxAddrPerMsg; i++ {
+		if err := msg.AddAddress(na); err != nil {
+			t.Fatalf("couldn't add address %d: %+v", i, err)
+		}
+	}
+	if err := msg.AddAddress(na); err == nil {
+		t.Errorf("adding more than %d addresses should fail", MaxAddrPerMsg)
+	}
+
+	// an IPv4 address must be exactly 4 bytes long
+	bad := &MsgAddrV2{AddrList: []*common.NetAddressV2{{NetworkID: common.NetIDIPv4, Addr: []byte{1, 2, 3}}}}
+	var buf bytes.Buffer
+	err := WriteMessageWithEncodingN(&buf, bad, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+	_, _, _, err = ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err == nil || !strings.Contains(err.Error(), "invalid ipv4 address size") {
+		t.Errorf("expected invalid size error, got %+v", err)
+	}
+}
+
+func TestAddrRoundTrip(t *testing.T) {
+	msg := &MsgAddr{}
+	err := msg.AddAddresses(
+		&common.NetAddress{Timestamp: time.Unix(0x495fab29, 0), Services: 1, IP: net.ParseIP("10.0.0.1").To16(), Port: 8333},
+		&common.NetAddress{Timestamp: time.Unix(0x495fab29, 0), Services: 1033, IP: net.ParseIP("2001:db8::1"), Port: 18333},
+	)
+	if err != nil {
+		t.Fatalf("couldn't add addresses: %+v", err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteMessageWithEncodingN(&buf, msg, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+
+	_, decoded, _, err := ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("decoded message mismatch\n got: %+v\nwant: %+v", decoded, msg)
+	}
+
+	buf.Reset()
+	err = WriteMessageWithEncodingN(&buf, &MsgGetAddr{}, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+	_, decoded, _, err = ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+	if _, ok := decoded.(*MsgGetAddr); !ok {
+		t.Errorf("expected getaddr, got %T", decoded)
+	}
+}
+
+func TestAddrWire(t *testing.T) {
+	wireMsg := wire.NewMsgAddr()
+	wireMsg.AddAddress(&wire.NetAddress{
+		Timestamp: time.Unix(0x495fab29, 0),
+		Services:  wire.SFNodeNetwork,
+		IP:        net.ParseIP("10.0.0.1"),
+		Port:      8333,
+	})
+
+	var buf bytes.Buffer
+	err := wire.WriteMessage(&buf, wireMsg, testProtocolVersion, wire.SimNet)
+	if err != nil {
+		t.Fatalf("wire write failed: %+v", err)
+	}
+	_, decoded, _, err := ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+	na := decoded.(*MsgAddr).AddrList[0]
+	if !na.Timestamp.Equal(time.Unix(0x495fab29, 0)) || na.Port != 8333 || !na.IP.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("read the wrong address %+v", na)
+	}
+
+	buf.Reset()
+	err = WriteMessageWithEncodingN(&buf, decoded, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+	wireDecoded, _, err := wire.ReadMessage(&buf, testProtocolVersion, wire.SimNet)
+	if err != nil {
+		t.Fatalf("wire read failed: %+v", err)
+	}
+	if !reflect.DeepEqual(wireDecoded, wireMsg) {
+		t.Errorf("btcd read the wrong addr message\n got: %+v\nwant: %+v", wireDecoded, wireMsg)
+	}
+}
+
+func TestAddrTimestampByProtocolVersion(t *testing.T) {
+	msg := &MsgAddr{AddrList: []*common.NetAddress{{
+		Timestamp: time.Unix(0x495fab29, 0),
+		IP:        net.ParseIP("10.0.0.1").To16(),
+		Port:      8333,
+	}}}
+
+	// the count, then services, IP and port, plus the timestamp from
+	// NetAddressTimeVersion on
+	tests := []struct {
+		pver uint32
+		size int
+	}{
+		{NetAddressTimeVersion - 1, 1 + 26},
+		{NetAddressTimeVersion, 1 + 30},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := msg.BtcEncode(&buf, test.pver, WitnessEncoding); err != nil {
+			t.Fatalf("encode failed at version %d: %+v", test.pver, err)
+		}
+		if buf.Len() != test.size {
+			t.Errorf("version %d: encoded %d bytes, want %d", test.pver, buf.Len(), test.size)
+		}
+
+		decoded := &MsgAddr{}
+		if err := decoded.BtcDecode(&buf, test.pver, WitnessEncoding); err != nil {
+			t.Fatalf("decode failed at version %d: %+v", test.pver, err)
+		}
+		hasTimestamp := !decoded.AddrList[0].Timestamp.IsZero()
+		if hasTimestamp != (test.pver >= NetAddressTimeVersion) {
+			t.Errorf("version %d: unexpected timestamp %v", test.pver, decoded.AddrList[0].Timestamp)
+		}
+	}
+}
+
+func TestAddrZeroTimestamp(t *testing.T) {
+	na := &common.NetAddress{IP: net.ParseIP("10.0.0.1").To16(), Port: 8333}
+	nav2 := &common.NetAddressV2{NetworkID: common.NetIDIPv4, Addr: []byte{10, 0, 0, 1}, Port: 8333}
+
+	// an unknown time is encoded as 0 rather than the wrapped zero time
+	for _, msg := range []Message{&MsgAddr{AddrList: []*common.NetAddress{na}},
+		&MsgAddrV2{AddrList: []*common.NetAddressV2{nav2}}} {
+		var buf bytes.Buffer
+		if err := msg.BtcEncode(&buf, testProtocolVersion, WitnessEncoding); err != nil {
+			t.Fatalf("%s: encode failed: %+v", msg.Command(), err)
+		}
+		if timestamp := binary.LittleEndian.Uint32(buf.Bytes()[1:5]); timestamp != 0 {
+			t.Errorf("%s: encoded timestamp %d, want 0", msg.Command(), timestamp)
+		}
+	}
+}
+
+func TestAddrLimits(t *testing.T) {
+	msg := &MsgAddr{}
+	na := &common.NetAddress{IP: net.ParseIP("10.0.0.1").To16(), Port: 8333}
+	for i := 0; i < MaxAddrPerMsg; i++ {
+		if err := msg.AddAddress(na); err != nil {
+			t.Fatalf("couldn't add address %d: %+v", i, err)
+		}
+	}
+	if err := msg.AddAddress(na); err == nil {
+		t.Errorf("adding more than %d addresses should fail", MaxAddrPerMsg)
+	}
+
+	msg.AddrList = append(msg.AddrList, na)
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, testProtocolVersion, WitnessEncoding); err == nil {
+		t.Errorf("encoding more than %d addresses should fail", MaxAddrPerMsg)
+	}
+
+	// a count above the limit is rejected before reading any address
+	buf.Reset()
+	buf.Write([]byte{0xfd, 0xe9, 0x03})
+	if err := (&MsgAddr{}).BtcDecode(&buf, testProtocolVersion, WitnessEncoding); err == nil {
+		t.Errorf("decoding more than %d addresses should fail", MaxAddrPerMsg)
+	}
+
+	msg.ClearAddresses()
+	if len(msg.AddrList) != 0 {
+		t.Errorf("expected no addresses after clearing, got %d", len(msg.AddrList))
+	}
+}
+
+func TestVersionEncodingByProtocolVersion(t *testing.T) {
+	// base fields: version 4, services 8, timestamp 8, AddrYou 26
+	const base = 46
+	userAgent := len("/handshake:0.1.0/") + 1
+
+	tests := []struct {
+		pver uint32
+		size int
+	}{
+		{60, base},
+		{AddrMeVersion, base + 26 + 8 + userAgent},
+		{LastBlockVersion, base + 26 + 8 + userAgent + 4},
+		{BIP0037Version - 1, base + 26 + 8 + userAgent + 4},
+		{BIP0037Version, base + 26 + 8 + userAgent + 4 + 1},
+	}
+
+	for _, test := range tests {
+		msg := testVersion()
+		msg.ProtocolVersion = int32(test.pver)
+		msg.DisableRelayTx = true
+
+		var buf bytes.Buffer
+		err := WriteMessageWithEncodingN(&buf, msg, test.pver, common.SimNet, WitnessEncoding)
+		if err != nil {
+			t.Fatalf("pver %d: write failed: %+v", test.pver, err)
+		}
+		if buf.Len() != MessageHeaderSize+test.size {
+			t.Errorf("pver %d: payload is %d bytes, want %d", test.pver,
+				buf.Len()-MessageHeaderSize, test.size)
+		}
+
+		_, decoded, _, err := ReadMessageWithEncodingN(&buf, test.pver, common.SimNet, WitnessEncoding)
+		if err != nil {
+			t.Fatalf("pver %d: read failed: %+v", test.pver, err)
+		}
+		got := decoded.(*MsgVersion)
+
+		hasAddrMe := test.pver >= AddrMeVersion
+		if (got.UserAgent == msg.UserAgent) != hasAddrMe || (got.Nonce == msg.Nonce) != hasAddrMe {
+			t.Errorf("pver %d: unexpected from address fields %+v", test.pver, got)
+		}
+		if (got.LastBlock == msg.LastBlock) != (test.pver >= LastBlockVersion) {
+			t.Errorf("pver %d: unexpected last block %d", test.pver, got.LastBlock)
+		}
+		if got.DisableRelayTx != (test.pver >= BIP0037Version) {
+			t.Errorf("pver %d: unexpected relay flag %v", test.pver, got.DisableRelayTx)
+		}
+	}
+}
+
+func TestVersionRelayFlagWire(t *testing.T) {
+	msg := testVersion()
+	msg.DisableRelayTx = true
+
+	var buf bytes.Buffer
+	err := WriteMessageWithEncodingN(&buf, msg, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+
+	decoded, _, err := wire.ReadMessage(&buf, testProtocolVersion, wire.SimNet)
+	if err != nil {
+		t.Fatalf("wire read failed: %+v", err)
+	}
+	if !decoded.(*wire.MsgVersion).DisableRelayTx {
+		t.Errorf("btcd didn't see the relay flag")
+	}
+}
+
+func TestPingPongWire(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteMessageWithEncodingN(&buf, &MsgPing{Nonce: 0x1122334455667788}, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+
+	decoded, _, err := wire.ReadMessage(&buf, testProtocolVersion, wire.SimNet)
+	if err != nil {
+		t.Fatalf("wire read failed: %+v", err)
+	}
+	if decoded.(*wire.MsgPing).Nonce != 0x1122334455667788 {
+		t.Errorf("btcd read the wrong ping nonce")
+	}
+
+	buf.Reset()
+	err = wire.WriteMessage(&buf, wire.NewMsgPong(42), testProtocolVersion, wire.SimNet)
+	if err != nil {
+		t.Fatalf("wire write failed: %+v", err)
+	}
+	_, msg, _, err := ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+	if msg.(*MsgPong).Nonce != 42 {
+		t.Errorf("read the wrong pong nonce")
+	}
+}
+
+func TestPingPongBIP0031(t *testing.T) {
+	// no nonce up to and including BIP0031Version
+	var buf bytes.Buffer
+	if err := (&MsgPing{Nonce: 1}).BtcEncode(&buf, BIP0031Version, WitnessEncoding); err != nil {
+		t.Fatalf("encode failed: %+v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("ping shouldn't carry a nonce at version %d", BIP0031Version)
+	}
+
+	if err := (&MsgPing{Nonce: 1}).BtcEncode(&buf, BIP0031Version+1, WitnessEncoding); err != nil {
+		t.Fatalf("encode failed: %+v", err)
+	}
+	if buf.Len() != 8 {
+		t.Errorf("ping should carry a nonce after version %d", BIP0031Version)
+	}
+
+	if err := (&MsgPong{}).BtcEncode(&buf, BIP0031Version, WitnessEncoding); err == nil {
+		t.Errorf("pong shouldn't be encoded at version %d", BIP0031Version)
+	}
+	if err := (&MsgPong{}).BtcDecode(&buf, BIP0031Version, WitnessEncoding); err == nil {
+		t.Errorf("pong shouldn't be decoded at version %d", BIP0031Version)
+	}
+}
+
+func TestTraceLogging(t *testing.T) {
+	var logBuf bytes.Buffer
+	logger := btclog.NewBackend(&logBuf).Logger("MSG")
+	logger.SetLevel(btclog.LevelTrace)
+	UseLogger(logger)
+	defer DisableLog()
+
+	var buf bytes.Buffer
+	err := WriteMessageWithEncodingN(&buf, &MsgPing{Nonce: 7}, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+	checksum := buf.Bytes()[20:24]
+	_, _, _, err = ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+
+	for _, want := range []string{
+		fmt.Sprintf("Sending ping message (8 bytes, checksum %x)", checksum),
+		fmt.Sprintf("Received ping message (8 bytes, checksum %x)", checksum),
+	} {
+		if !strings.Contains(logBuf.String(), want) {
+			t.Errorf("log %q doesn't contain %q", logBuf.String(), want)
+		}
+	}
+}
+
+func TestFeatureMessagesWire(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteMessageWithEncodingN(&buf, &MsgFeeFilter{MinFee: 1234}, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+	err = WriteMessageWithEncodingN(&buf, &MsgSendHeaders{}, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+
+	decoded, _, err := wire.ReadMessage(&buf, testProtocolVersion, wire.SimNet)
+	if err != nil {
+		t.Fatalf("wire read failed: %+v", err)
+	}
+	if decoded.(*wire.MsgFeeFilter).MinFee != 1234 {
+		t.Errorf("btcd read the wrong fee")
+	}
+	decoded, _, err = wire.ReadMessage(&buf, testProtocolVersion, wire.SimNet)
+	if err != nil {
+		t.Fatalf("wire read failed: %+v", err)
+	}
+	if _, ok := decoded.(*wire.MsgSendHeaders); !ok {
+		t.Errorf("expected sendheaders, got %T", decoded)
+	}
+}
+
+func TestSendCmpctRoundTrip(t *testing.T) {
+	msg := &MsgSendCmpct{Announce: true, Version: 2}
+
+	var buf bytes.Buffer
+	err := WriteMessageWithEncodingN(&buf, msg, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("write failed: %+v", err)
+	}
+	// announce flag and version
+	if payload := buf.Bytes()[MessageHeaderSize:]; !bytes.Equal(payload, []byte{1, 2, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("unexpected payload %x", payload)
+	}
+
+	_, decoded, _, err := ReadMessageWithEncodingN(&buf, testProtocolVersion, common.SimNet, WitnessEncoding)
+	if err != nil {
+		t.Fatalf("read failed: %+v", err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("decoded %+v, want %+v", decoded, msg)
+	}
+
+	if err := msg.BtcEncode(&buf, SendCmpctVersion-1, WitnessEncoding); err == nil {
+		t.Errorf("sendcmpct shouldn't be encoded before version %d", SendCmpctVersion)
+	}
+}
@@ -1,6 +1,9 @@
 package message
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"handshake/common"
 	"io"
 	"time"
@@ -11,6 +14,24 @@ const (
 	CmdVersion = "version"
 )
 
+const (
+	// AddrMeVersion is the protocol version which added the from address,
+	// nonce and user agent fields to the version message.
+	AddrMeVersion uint32 = 106
+
+	// LastBlockVersion is the protocol version which added the last known
+	// block field to the version message.
+	LastBlockVersion uint32 = 209
+
+	// BIP0037Version is the protocol version which added the relay
+	// transactions field to the version message (BIP37).
+	BIP0037Version uint32 = 70001
+)
+
+// MaxUserAgentLen is the maximum allowed length for the user agent field in a
+// version message (MsgVersion).
+const MaxUserAgentLen = 256
+
 // MsgVersion implements the Message interface and represents a bitcoin version message
 type MsgVersion struct {
 	// Version of the protocol the node is using.
@@ -49,7 +70,99 @@ func (msg *MsgVersion) Command() string {
 	return CmdVersion
 }
 
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// The version message is special in that the protocol version hasn't been
+// negotiated yet.  As a result, the pver field is ignored and the fields are
+// gated on the protocol version the sender advertises in the message itself.
+// Fields added in newer versions are also optional, so r must be a
+// *bytes.Buffer so the number of remaining bytes can be ascertained.
+//
+// This is part of the Message interface implementation.
+func (msg *MsgVersion) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	buf, ok := r.(*bytes.Buffer)
+	if !ok {
+		return errors.New("MsgVersion.BtcDecode reader is not a *bytes.Buffer")
+	}
+
+	err := readElements(buf, &msg.ProtocolVersion, &msg.Services,
+		&msg.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	err = readNetAddress(buf, pver, &msg.AddrYou, false)
+	if err != nil {
+		return err
+	}
+
+	// Reset the optional fields so a reused message doesn't keep stale
+	// values the sender never sent.  Relaying was the default before the
+	// relay field existed.
+	msg.AddrMe = common.NetAddress{}
+	msg.Nonce = 0
+	msg.UserAgent = ""
+	msg.LastBlock = 0
+	msg.DisableRelayTx = false
+
+	senderVersion := uint32(msg.ProtocolVersion)
+	if msg.ProtocolVersion < 0 {
+		senderVersion = 0
+	}
+
+	// Protocol versions >= AddrMeVersion added a from address, nonce, and
+	// user agent field.  They are only considered present if there are
+	// bytes remaining in the message.
+	if senderVersion >= AddrMeVersion && buf.Len() > 0 {
+		err = readNetAddress(buf, pver, &msg.AddrMe, false)
+		if err != nil {
+			return err
+		}
+	}
+	if senderVersion >= AddrMeVersion && buf.Len() > 0 {
+		err = readElement(buf, &msg.Nonce)
+		if err != nil {
+			return err
+		}
+	}
+	if senderVersion >= AddrMeVersion && buf.Len() > 0 {
+		userAgent, err := ReadVarString(buf, pver)
+		if err != nil {
+			return err
+		}
+		if len(userAgent) > MaxUserAgentLen {
+			str := fmt.Sprintf("user agent too long [len %v, max %v]",
+				len(userAgent), MaxUserAgentLen)
+			return errors.New(str)
+		}
+		msg.UserAgent = userAgent
+	}
+
+	// Protocol versions >= LastBlockVersion added a last known block field.
+	if senderVersion >= LastBlockVersion && buf.Len() > 0 {
+		err = readElement(buf, &msg.LastBlock)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Protocol versions >= BIP0037Version added the relay transactions
+	// field.
+	if senderVersion >= BIP0037Version && buf.Len() > 0 {
+		// The wire encoding for the field is true when transactions
+		// should be relayed, so reverse it for the DisableRelayTx field.
+		var relayTx bool
+		readElement(buf, &relayTx)
+		msg.DisableRelayTx = !relayTx
+	}
+
+	return nil
+}
+
 // BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// Fields are written following the historical rules for pver: the from
+// address, nonce and user agent from AddrMeVersion, the last block from
+// LastBlockVersion and the relay flag from BIP0037Version.
+//
 // This is part of the Message interface implementation.
 func (msg *MsgVersion) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
 	err := writeElements(w, msg.ProtocolVersion, msg.Services,
@@ -63,6 +176,12 @@ func (msg *MsgVersion) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding)
 		return err
 	}
 
+	// The remaining fields were added over time, so only write the ones
+	// the protocol version we speak knows about.
+	if pver < AddrMeVersion {
+		return nil
+	}
+
 	err = writeNetAddress(w, pver, &msg.AddrMe, false)
 	if err != nil {
 		return err
@@ -78,16 +197,36 @@ func (msg *MsgVersion) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding)
 		return err
 	}
 
+	if pver < LastBlockVersion {
+		return nil
+	}
+
 	err = writeElement(w, msg.LastBlock)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if pver < BIP0037Version {
+		return nil
+	}
+
+	// The wire encoding is true when transactions should be relayed.
+	return writeElement(w, !msg.DisableRelayTx)
 }
 
+// MsgVerAck defines a bitcoin verack message which is used for a peer to
+// acknowledge a version message (MsgVersion) after it has used the information
+// to negotiate parameters.  It implements the Message interface.
+//
+// This message has no payload.
 type MsgVerAck struct{}
 
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgVerAck) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	return nil
+}
+
 // BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
 // This is part of the Message interface implementation.
 func (msg *MsgVerAck) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"handshake/checker"
+	"handshake/message"
+	"handshake/peer"
+	"handshake/scan"
+)
+
+// Output formats accepted by --output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// jsonCommands lists the commands which print a JSON report with --output
+// json.  The others only print text.
+var jsonCommands = map[string]bool{
+	"handshake": true,
+}
+
+// errorClass groups failures by what a caller can do about them.
+type errorClass int
+
+const (
+	classOther errorClass = iota
+	classAddress
+	classConnection
+	classTimeout
+	classProtocol
+	classNetwork
+)
+
+// Map of error classes back to their name used in the JSON output.
+var errorClassStrings = map[errorClass]string{
+	classOther:      "other",
+	classAddress:    "address",
+	classConnection: "connection",
+	classTimeout:    "timeout",
+	classProtocol:   "protocol",
+	classNetwork:    "network",
+}
+
+// String returns the errorClass in human-readable form.
+func (c errorClass) String() string {
+	if s, ok := errorClassStrings[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown errorClass (%d)", int(c))
+}
+
+// Exit codes of the application.  Failures which can't be attributed to a
+// class, including invalid options, exit with exitFailure.
+const (
+	exitFailure      = 1
+	exitAddress      = 2
+	exitConnection   = 3
+	exitTimeout      = 4
+	exitProtocol     = 5
+	exitWrongNetwork = 6
+)
+
+// exitCode returns the exit code for the class.
+func (c errorClass) exitCode() int {
+	switch c {
+	case classAddress:
+		return exitAddress
+	case classConnection:
+		return exitConnection
+	case classTimeout:
+		return exitTimeout
+	case classProtocol:
+		return exitProtocol
+	case classNetwork:
+		return exitWrongNetwork
+	}
+	return exitFailure
+}
+
+// classifyError returns the class of err.  When every handshake attempt
+// failed, the last failure decides.
+func classifyError(err error) errorClass {
+	var retryErr *peer.RetryError
+	if errors.As(err, &retryErr) && len(retryErr.Errors) > 0 {
+		err = retryErr.Errors[len(retryErr.Errors)-1]
+	}
+
+	var unexpected *message.ErrUnexpectedMessage
+	var timeout *message.ErrHandshakeTimeout
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+
+	switch {
+	case err == nil:
+		return classOther
+
+	case errors.Is(err, message.ErrBadMagic):
+		return classNetwork
+
+	case errors.Is(err, peer.ErrInvalidAddress):
+		return classAddress
+
+	case errors.As(err, &timeout),
+		errors.Is(err, context.DeadlineExceeded):
+		return classTimeout
+
+	case errors.Is(err, message.ErrVersionTooOld),
+		errors.Is(err, message.ErrSelfConnection),
+		errors.Is(err, message.ErrMissingServices),
+		errors.Is(err, message.ErrChecksumMismatch),
+		errors.Is(err, message.ErrPayloadTooLarge),
+		errors.Is(err, checker.ErrInvalidHandshake),
+		errors.As(err, &unexpected):
+		return classProtocol
+
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsTimeout {
+			return classTimeout
+		}
+		if dnsErr.IsNotFound {
+			return classAddress
+		}
+		return classConnection
+
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return classTimeout
+
+	case errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE),
+		errors.As(err, &opErr):
+		return classConnection
+	}
+
+	return classOther
+}
+
+// handshakeReport is the JSON output of the handshake command.
+type handshakeReport struct {
+	Address           string         `json:"address"`
+	Network           string         `json:"network"`
+	Success           bool           `json:"success"`
+	Remote            *remoteReport  `json:"remote,omitempty"`
+	NegotiatedVersion uint32         `json:"negotiated_version,omitempty"`
+	Features          *featureReport `json:"features,omitempty"`
+	Timings           *timingReport  `json:"timings,omitempty"`
+	Error             *errorReport   `json:"error,omitempty"`
+}
+
+// remoteReport holds what the remote peer announced in its version message.
+type remoteReport struct {
+	Addr            string  `json:"addr"`
+	ProtocolVersion uint32  `json:"protocol_version"`
+	UserAgent       string  `json:"user_agent"`
+	Services        uint64  `json:"services"`
+	StartHeight     int32   `json:"start_height"`
+	RelayTx         bool    `json:"relay_tx"`
+	ClockOffsetMs   float64 `json:"clock_offset_ms"`
+}
+
+// featureReport holds the features agreed on during the handshake.
+type featureReport struct {
+	AddrV2     bool `json:"addrv2"`
+	WtxidRelay bool `json:"wtxidrelay"`
+}
+
+// timingReport holds the handshake phase timings in milliseconds.
+type timingReport struct {
+	DialMs    float64 `json:"dial_ms"`
+	VersionMs float64 `json:"version_ms"`
+	VerAckMs  float64 `json:"verack_ms"`
+	TotalMs   float64 `json:"total_ms"`
+}
+
+// errorReport describes why the handshake failed.
+type errorReport struct {
+	Class   string `json:"class"`
+	Message string `json:"message"`
+}
+
+// newHandshakeReport returns the report of a handshake with addr, which
+// failed with err when res is nil.
+func newHandshakeReport(addr string, res *peer.HandshakeResult, err error) *handshakeReport {
+	report := &handshakeReport{
+		Address: addr,
+		Network: cfg.Network,
+		Success: err == nil,
+	}
+	if err != nil {
+		report.Error = &errorReport{
+			Class:   classifyError(err).String(),
+			Message: err.Error(),
+		}
+		return report
+	}
+
+	report.Remote = &remoteReport{
+		Addr:            res.Conn.RemoteAddr().String(),
+		ProtocolVersion: res.RemoteProtocolVersion,
+		UserAgent:       res.UserAgent,
+		Services:        uint64(res.Services),
+		StartHeight:     res.StartHeight,
+		RelayTx:         res.RelayTx,
+		ClockOffsetMs:   milliseconds(res.ClockOffset),
+	}
+	report.NegotiatedVersion = res.NegotiatedVersion
+	report.Features = &featureReport{
+		AddrV2:     res.Features.AddrV2,
+		WtxidRelay: res.Features.WtxidRelay,
+	}
+	report.Timings = &timingReport{
+		DialMs:    milliseconds(res.Timings.Dial),
+		VersionMs: milliseconds(res.Timings.Version),
+		VerAckMs:  milliseconds(res.Timings.VerAck),
+		TotalMs:   milliseconds(res.Timings.Total),
+	}
+	return report
+}
+
+// scanRecord is the record of a scanned node.
+type scanRecord struct {
+	Address         string       `json:"address"`
+	Success         bool         `json:"success"`
+	UserAgent       string       `json:"user_agent,omitempty"`
+	ProtocolVersion uint32       `json:"protocol_version,omitempty"`
+	Services        uint64       `json:"services,omitempty"`
+	StartHeight     int32        `json:"start_height,omitempty"`
+	HandshakeMs     float64      `json:"handshake_ms,omitempty"`
+	RTTMs           float64      `json:"rtt_ms,omitempty"`
+	Error           *errorReport `json:"error,omitempty"`
+}
+
+// newScanRecord returns the record of res.
+func newScanRecord(res *scan.Result) *scanRecord {
+	record := &scanRecord{
+		Address:         res.Address,
+		Success:         res.Err == nil,
+		UserAgent:       res.UserAgent,
+		ProtocolVersion: res.ProtocolVersion,
+		Services:        uint64(res.Services),
+		StartHeight:     res.StartHeight,
+		HandshakeMs:     milliseconds(res.HandshakeTime),
+		RTTMs:           milliseconds(res.RTT),
+	}
+	if res.Err != nil {
+		record.Error = &errorReport{
+			Class:   classifyError(res.Err).String(),
+			Message: res.Err.Error(),
+		}
+	}
+	return record
+}
+
+// scanWriter writes the records of scanned nodes as they come in.
+type scanWriter interface {
+	Write(res *scan.Result) error
+	Flush() error
+}
+
+// jsonScanWriter writes a line of JSON per node.
+type jsonScanWriter struct {
+	w io.Writer
+}
+
+func newJSONScanWriter(w io.Writer) *jsonScanWriter {
+	return &jsonScanWriter{w: w}
+}
+
+func (w *jsonScanWriter) Write(res *scan.Result) error {
+	return writeJSON(w.w, newScanRecord(res))
+}
+
+func (w *jsonScanWriter) Flush() error {
+	return nil
+}
+
+// csvScanHeader names the columns written by csvScanWriter.
+var csvScanHeader = []string{
+	"address", "success", "user_agent", "protocol_version", "services",
+	"start_height", "handshake_ms", "rtt_ms", "error_class", "error",
+}
+
+// csvScanWriter writes a CSV row per node after a header row.
+type csvScanWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func newCSVScanWriter(w io.Writer) *csvScanWriter {
+	return &csvScanWriter{w: csv.NewWriter(w)}
+}
+
+func (w *csvScanWriter) Write(res *scan.Result) error {
+	if !w.headerWritten {
+		if err := w.w.Write(csvScanHeader); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	record := newScanRecord(res)
+	var errClass, errMsg string
+	if record.Error != nil {
+		errClass, errMsg = record.Error.Class, record.Error.Message
+	}
+	err := w.w.Write([]string{
+		record.Address,
+		strconv.FormatBool(record.Success),
+		record.UserAgent,
+		strconv.FormatUint(uint64(record.ProtocolVersion), 10),
+		strconv.FormatUint(record.Services, 10),
+		strconv.FormatInt(int64(record.StartHeight), 10),
+		strconv.FormatFloat(record.HandshakeMs, 'f', 3, 64),
+		strconv.FormatFloat(record.RTTMs, 'f', 3, 64),
+		errClass,
+		errMsg,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Stream the rows as the handshakes finish.
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvScanWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// writeScanSummary writes a human-readable summary of a scan which took
+// elapsed, with the number of failures by class.
+func writeScanSummary(w io.Writer, summary *scan.Summary, classes map[errorClass]int, elapsed time.Duration) {
+	fmt.Fprintf(w, "Scanned %d nodes in %v: %d succeeded, %d failed\n",
+		summary.Total, elapsed.Round(time.Millisecond), summary.Succeeded,
+		summary.Failed)
+	if summary.Succeeded > 0 {
+		fmt.Fprintf(w, "Average round trip time %v\n", summary.AvgRTT)
+	}
+	if len(classes) == 0 {
+		return
+	}
+
+	counts := make([]string, 0, len(classes))
+	for class, count := range classes {
+		counts = append(counts, fmt.Sprintf("%v %d", class, count))
+	}
+	sort.Strings(counts)
+	fmt.Fprintf(w, "Failures: %s\n", strings.Join(counts, ", "))
+}
+
+// writeJSON writes v to w as a single line of JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// milliseconds returns d in fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"handshake/checker"
+	"handshake/common"
+	"handshake/message"
+	"handshake/peer"
+	"handshake/scan"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	btcdpeer "github.com/btcsuite/btcd/peer"
+)
+
+// TestClassifyError ensures failures map to their class and exit code.
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want errorClass
+		code int
+	}{
+		{
+			"invalid address",
+			fmt.Errorf("%w: %q", peer.ErrInvalidAddress, "x"),
+			classAddress, exitAddress,
+		},
+		{
+			"unknown host",
+			&net.DNSError{Err: "no such host", IsNotFound: true},
+			classAddress, exitAddress,
+		},
+		{
+			"connection refused",
+			&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED},
+			classConnection, exitConnection,
+		},
+		{
+			"eof",
+			io.EOF,
+			classConnection, exitConnection,
+		},
+		{
+			"handshake timeout",
+			&message.ErrHandshakeTimeout{Phase: message.PhaseVerAck},
+			classTimeout, exitTimeout,
+		},
+		{
+			"context deadline",
+			context.DeadlineExceeded,
+			classTimeout, exitTimeout,
+		},
+		{
+			"version too old",
+			fmt.Errorf("%w: 106", message.ErrVersionTooOld),
+			classProtocol, exitProtocol,
+		},
+		{
+			"missing services",
+			fmt.Errorf("%w: advertised none, missing witness", message.ErrMissingServices),
+			classProtocol, exitProtocol,
+		},
+		{
+			"unexpected message",
+			&message.ErrUnexpectedMessage{Command: "ping"},
+			classProtocol, exitProtocol,
+		},
+		{
+			"invalid handshake",
+			fmt.Errorf("%w: x", checker.ErrInvalidHandshake),
+			classProtocol, exitProtocol,
+		},
+		{
+			"wrong network",
+			fmt.Errorf("%w [1]", message.ErrBadMagic),
+			classNetwork, exitWrongNetwork,
+		},
+		{
+			"last attempt decides",
+			&peer.RetryError{Errors: []error{
+				io.EOF,
+				&message.ErrHandshakeTimeout{Phase: message.PhaseVersion},
+			}},
+			classTimeout, exitTimeout,
+		},
+		{
+			"other",
+			errors.New("something"),
+			classOther, exitFailure,
+		},
+	}
+
+	for _, test := range tests {
+		class := classifyError(test.err)
+		if class != test.want {
+			t.Errorf("%s: got class %v, want %v", test.name, class,
+				test.want)
+		}
+		if code := class.exitCode(); code != test.code {
+			t.Errorf("%s: got exit code %d, want %d", test.name, code,
+				test.code)
+		}
+	}
+}
+
+// TestHandshakeReportError ensures a failed handshake is reported with its
+// error class.
+func TestHandshakeReportError(t *testing.T) {
+	err := &peer.RetryError{Errors: []error{syscall.ECONNREFUSED}}
+	report := newHandshakeReport("127.0.0.1:1", nil, err)
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, report); err != nil {
+		t.Fatalf("writeJSON: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if decoded["success"] != false {
+		t.Errorf("success: got %v, want false", decoded["success"])
+	}
+	if _, ok := decoded["timings"]; ok {
+		t.Errorf("unexpected timings in %s", buf.String())
+	}
+	errReport, _ := decoded["error"].(map[string]interface{})
+	if errReport["class"] != "connection" {
+		t.Errorf("error class: got %v, want connection", errReport["class"])
+	}
+}
+
+// serveWrongNetwork runs a node on listener which either drops messages of
+// other networks like btcd or, when answer is set, sends its version on the
+// simulation test network right away.
+func serveWrongNetwork(listener net.Listener, answer bool) {
+	peerCfg := &btcdpeer.Config{
+		UserAgentName:    "peer",
+		UserAgentVersion: "1.0.0",
+		ChainParams:      &chaincfg.SimNetParams,
+		AllowSelfConns:   true,
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		if !answer {
+			p := btcdpeer.NewInboundPeer(peerCfg)
+			p.AssociateConnection(conn)
+			continue
+		}
+
+		msg := &message.MsgVersion{ProtocolVersion: 70016, UserAgent: "/peer:1.0.0/"}
+		message.WriteMessageWithEncodingN(conn, msg, 70016, common.SimNet,
+			message.WitnessEncoding)
+		time.AfterFunc(time.Second, func() { conn.Close() })
+	}
+}
+
+// TestWrongNetworkExitCode ensures a handshake with a node on another network
+// exits with exitWrongNetwork when the node proves it with its magic and with
+// exitConnection when it just hangs up.
+func TestWrongNetworkExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer bool
+		code   int
+	}{
+		{"hangs up", false, exitConnection},
+		{"answers", true, exitWrongNetwork},
+	}
+
+	defer func(orig *config) { cfg = orig }(cfg)
+	for _, test := range tests {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen: %v", err)
+		}
+		go serveWrongNetwork(listener, test.answer)
+
+		cfg = defaultConfig()
+		args := []string{"-n", "mainnet", "--retries", "1", "handshake",
+			listener.Addr().String()}
+		err = parseArgs(cfg, args)
+		listener.Close()
+		if err == nil {
+			t.Fatalf("%s: handshake with a simnet node on mainnet "+
+				"succeeded", test.name)
+		}
+		if code := classifyError(err).exitCode(); code != test.code {
+			t.Errorf("%s: exit code: got %d, want %d (%v)", test.name,
+				code, test.code, err)
+		}
+	}
+}
+
+// TestScanWriters ensures scan results are written as JSON lines and CSV.
+func TestScanWriters(t *testing.T) {
+	results := []scan.Result{
+		{
+			Address:         "127.0.0.1:18555",
+			UserAgent:       "/btcwire:0.5.0/",
+			ProtocolVersion: 70016,
+			RTT:             2 * time.Millisecond,
+		},
+		{
+			Address: "127.0.0.1:1",
+			Err:     syscall.ECONNREFUSED,
+		},
+	}
+
+	var jsonBuf, csvBuf bytes.Buffer
+	jsonWriter := newJSONScanWriter(&jsonBuf)
+	csvWriter := newCSVScanWriter(&csvBuf)
+	for i := range results {
+		if err := jsonWriter.Write(&results[i]); err != nil {
+			t.Fatalf("json Write: %v", err)
+		}
+		if err := csvWriter.Write(&results[i]); err != nil {
+			t.Fatalf("csv Write: %v", err)
+		}
+	}
+	if err := csvWriter.Flush(); err != nil {
+		t.Fatalf("csv Flush: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(jsonBuf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 JSON lines, got %q", jsonBuf.String())
+	}
+	var record scanRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !record.Success || record.RTTMs != 2 || record.UserAgent != "/btcwire:0.5.0/" {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	rows, err := csv.NewReader(&csvBuf).ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "address" {
+		t.Fatalf("expected header and 2 rows, got %q", rows)
+	}
+	if rows[2][1] != "false" || rows[2][8] != "connection" {
+		t.Errorf("unexpected failure row %q", rows[2])
+	}
+}
@@ -0,0 +1,122 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"handshake/common"
+)
+
+// ErrInvalidAddress is returned when a peer address can't be parsed.
+var ErrInvalidAddress = errors.New("incorrect peer address format")
+
+// Resolver looks up the IP addresses of a host name.  *net.Resolver satisfies
+// it; tests and callers with their own DNS policy can inject another one.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// splitAddress splits a peer address into host and port.  It accepts IPv4
+// addresses, IPv6 addresses with or without brackets and with an optional
+// zone, and host names.  The port is optional and falls back to the default
+// port of the network.
+func splitAddress(peerAddress string, network common.BitcoinNet) (string, uint16, error) {
+	host, port, err := net.SplitHostPort(peerAddress)
+	if err != nil {
+		// There is no port, so the whole address is the host.
+		host, port = peerAddress, ""
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+	} else if port == "" {
+		return "", 0, fmt.Errorf("%w: empty port in %q", ErrInvalidAddress, peerAddress)
+	}
+
+	// Anything but an IP literal must be a plain host name.
+	if host == "" || (parseIP(host) == nil && strings.ContainsAny(host, "[]%: ")) {
+		return "", 0, fmt.Errorf("%w: %q", ErrInvalidAddress, peerAddress)
+	}
+
+	if port == "" {
+		params, ok := common.ParamsForNet(network)
+		if !ok || params.DefaultPort == 0 {
+			return "", 0, fmt.Errorf("%w: no port in %q and no default port for network %v",
+				ErrInvalidAddress, peerAddress, network)
+		}
+		return host, params.DefaultPort, nil
+	}
+
+	// Convert port string to uint16
+	portUint64, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || portUint64 == 0 {
+		return "", 0, fmt.Errorf("%w: invalid port in %q", ErrInvalidAddress, peerAddress)
+	}
+
+	return host, uint16(portUint64), nil
+}
+
+// parseIP parses an IP address literal which, for IPv6, may carry a zone.
+func parseIP(host string) *net.IPAddr {
+	ip, zone, _ := strings.Cut(host, "%")
+	addr := net.ParseIP(ip)
+	if addr == nil || (zone != "" && addr.To4() != nil) {
+		return nil
+	}
+	return &net.IPAddr{IP: addr, Zone: zone}
+}
+
+// resolveAddress turns a peer address into a TCP address, resolving host names
+// through resolver.
+func resolveAddress(ctx context.Context, peerAddress string, network common.BitcoinNet, resolver Resolver) (*net.TCPAddr, error) {
+	host, port, err := splitAddress(peerAddress, network)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := parseIP(host); ip != nil {
+		return &net.TCPAddr{IP: ip.IP, Port: int(port), Zone: ip.Zone}, nil
+	}
+
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	return &net.TCPAddr{IP: ips[0].IP, Port: int(port), Zone: ips[0].Zone}, nil
+}
+
+// dialTarget returns the address to hand to the dialer and the remote address
+// to advertise as AddrYou.  Host names are resolved locally unless the dialer
+// resolves them remotely, in which case only IP literals end up in AddrYou.
+func dialTarget(ctx context.Context, peerAddress string, network common.BitcoinNet, cfg *HandshakeConfig) (string, *net.TCPAddr, error) {
+	if !resolvesRemotely(cfg.dialer()) {
+		addr, err := resolveAddress(ctx, peerAddress, network, cfg.Resolver)
+		if err != nil {
+			return "", nil, err
+		}
+		return addr.String(), addr, nil
+	}
+
+	host, port, err := splitAddress(peerAddress, network)
+	if err != nil {
+		return "", nil, err
+	}
+
+	addrYou := &net.TCPAddr{Port: int(port)}
+	if ip := parseIP(host); ip != nil {
+		addrYou.IP = ip.IP
+		host = ip.IP.String()
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), addrYou, nil
+}
@@ -0,0 +1,83 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"handshake/common"
+)
+
+// staticResolver resolves host names from a fixed table
+type staticResolver map[string][]net.IPAddr
+
+func (r staticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+func TestResolveAddress(t *testing.T) {
+	resolver := staticResolver{
+		"seed.example": {{IP: net.ParseIP("10.0.0.7")}},
+	}
+
+	tests := []struct {
+		address string
+		network common.BitcoinNet
+		want    string
+	}{
+		{"35.175.179.123:18333", common.MainNet, "35.175.179.123:18333"},
+		{"35.175.179.123", common.MainNet, "35.175.179.123:8333"},
+		{"[2001:db8::1]:8333", common.MainNet, "[2001:db8::1]:8333"},
+		{"[2001:db8::1]", common.TestNet3, "[2001:db8::1]:18333"},
+		{"2001:db8::1", common.SimNet, "[2001:db8::1]:18555"},
+		{"seed.example", common.SigNet, "10.0.0.7:38333"},
+		{"seed.example", common.TestNet4, "10.0.0.7:48333"},
+		{"[fe80::1%eth0]:8333", common.MainNet, "[fe80::1%eth0]:8333"},
+		{"fe80::1%eth0", common.MainNet, "[fe80::1%eth0]:8333"},
+		{"seed.example:18333", common.MainNet, "10.0.0.7:18333"},
+		{"seed.example", common.TestNet, "10.0.0.7:18444"},
+	}
+
+	for _, test := range tests {
+		addr, err := resolveAddress(context.Background(), test.address, test.network, resolver)
+		if err != nil {
+			t.Errorf("%s: unexpected error %+v", test.address, err)
+			continue
+		}
+		if addr.String() != test.want {
+			t.Errorf("%s: got %s, want %s", test.address, addr, test.want)
+		}
+	}
+}
+
+func TestResolveAddressInvalid(t *testing.T) {
+	invalid := []string{
+		"",
+		":8333",
+		"1.2.3.4:",
+		"1.2.3.4:0",
+		"1.2.3.4:70000",
+		"1.2.3.4:port",
+		"2001:db8::zz",
+		"1.2.3.4%eth0",
+		"[seed.example",
+	}
+
+	for _, address := range invalid {
+		_, err := resolveAddress(context.Background(), address, common.MainNet, staticResolver{})
+		if !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("%q: expected ErrInvalidAddress, got %+v", address, err)
+		}
+	}
+
+	_, err := resolveAddress(context.Background(), "unknown.example", common.MainNet, staticResolver{})
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Errorf("expected a resolver error, got %+v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package peer
+
+import (
+	"net"
+	"time"
+
+	"handshake/common"
+)
+
+// DefaultDialTimeout is how long we wait for the tcp connection to be
+// established
+const DefaultDialTimeout = 1 * time.Second
+
+// DefaultWriteTimeout is how long we allow writing our version, and later the
+// messages up to our verack, to take
+const DefaultWriteTimeout = 1 * time.Second
+
+// HandshakeConfig describes the node we present ourselves as to the remote
+// peer and the limits applied while negotiating.  It lets the same code path
+// impersonate different node profiles, e.g. a pruned node or a light client.
+type HandshakeConfig struct {
+	// UserAgent advertised in our version message.
+	UserAgent string
+
+	// Services advertised in our version message.
+	Services common.ServiceFlag
+
+	// RequiredServices the remote peer has to advertise.  Peers missing
+	// any of them are rejected with message.ErrMissingServices.
+	RequiredServices common.ServiceFlag
+
+	// Nonce sent in our version message to detect self connections.  A
+	// random nonce is generated for every attempt when it is zero.
+	Nonce uint64
+
+	// LastBlock is the start height advertised in our version message.
+	LastBlock int32
+
+	// DisableRelayTx asks the remote peer not to announce transactions.
+	DisableRelayTx bool
+
+	// AddrMe is the local address advertised in our version message.
+	AddrMe common.NetAddress
+
+	// DialTimeout bounds establishing the tcp connection.  Zero means no
+	// timeout, as for the other timeouts.
+	DialTimeout time.Duration
+
+	// WriteTimeout bounds each of our two write phases: sending our
+	// version, and sending the messages up to our verack once the remote
+	// version arrived.  Waiting for the remote peer doesn't count against
+	// it, so it may well be shorter than ReadTimeout.
+	WriteTimeout time.Duration
+
+	// ReadTimeout bounds reading the remote handshake messages, from
+	// connecting up to the remote verack.
+	ReadTimeout time.Duration
+
+	// RetryPolicy decides whether and when a failed attempt is retried.
+	// The handshake is attempted only once when it is nil.
+	RetryPolicy RetryPolicy
+
+	// OnAttempt, when set, is called after every handshake attempt.
+	OnAttempt func(Attempt)
+
+	// Resolver resolves host names in peer addresses.  net.DefaultResolver
+	// is used when it is nil.  It isn't consulted when Dialer resolves host
+	// names remotely.
+	Resolver Resolver
+
+	// AllowSelfConns disables the detection of connections to ourselves.
+	// It is only useful for testing.
+	AllowSelfConns bool
+
+	// Dialer establishes the connection.  A plain net.Dialer is used when
+	// it is nil.  Use a SOCKS5Dialer to connect through a proxy such as Tor.
+	Dialer Dialer
+}
+
+// DefaultHandshakeConfig returns the configuration used by Handshake.
+func DefaultHandshakeConfig() *HandshakeConfig {
+	return &HandshakeConfig{
+		UserAgent:    DefaultUserAgent,
+		DialTimeout:  DefaultDialTimeout,
+		WriteTimeout: DefaultWriteTimeout,
+		ReadTimeout:  NegotiationTimeout,
+		RetryPolicy:  DefaultRetryPolicy(),
+	}
+}
+
+// dialer returns the dialer to use for a handshake attempt.
+func (cfg *HandshakeConfig) dialer() Dialer {
+	if cfg.Dialer != nil {
+		return cfg.Dialer
+	}
+	return &net.Dialer{KeepAlive: 0}
+}
+
+// nonce returns the nonce to use for a single handshake attempt.
+func (cfg *HandshakeConfig) nonce() (uint64, error) {
+	if cfg.Nonce != 0 {
+		return cfg.Nonce, nil
+	}
+	return common.RandomUint64()
+}
@@ -0,0 +1,89 @@
+package peer
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/btcsuite/go-socks/socks"
+)
+
+// Dialer establishes the connection to the remote peer.  *net.Dialer
+// satisfies it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// remoteResolver is implemented by dialers which resolve host names on the far
+// side, e.g. a proxy.  Peer addresses are then passed to them unresolved so no
+// DNS query is made from this host.
+type remoteResolver interface {
+	ResolvesRemotely() bool
+}
+
+// resolvesRemotely reports whether dialer resolves host names itself.
+func resolvesRemotely(dialer Dialer) bool {
+	r, ok := dialer.(remoteResolver)
+	return ok && r.ResolvesRemotely()
+}
+
+// SOCKS5Dialer dials peers through a SOCKS5 proxy such as Tor.  Host names are
+// resolved by the proxy and, with Tor stream isolation, every connection uses
+// its own random credentials so it gets a separate circuit.
+type SOCKS5Dialer struct {
+	proxy *socks.Proxy
+}
+
+// NewSOCKS5Dialer returns a dialer using the SOCKS5 proxy at proxyAddr.  The
+// credentials are optional and ignored when torIsolation is set.
+func NewSOCKS5Dialer(proxyAddr, username, password string, torIsolation bool) *SOCKS5Dialer {
+	return &SOCKS5Dialer{
+		proxy: &socks.Proxy{
+			Addr:         proxyAddr,
+			Username:     username,
+			Password:     password,
+			TorIsolation: torIsolation,
+		},
+	}
+}
+
+// DialContext connects to address through the proxy.  This is part of the
+// Dialer interface implementation.
+func (d *SOCKS5Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := d.proxy.DialTimeout(network, address, timeout)
+		done <- result{conn, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		// The proxy negotiation can't be interrupted, so drop the
+		// connection as soon as it completes.
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// ResolvesRemotely reports that host names are resolved by the proxy.
+func (d *SOCKS5Dialer) ResolvesRemotely() bool {
+	return true
+}
@@ -0,0 +1,281 @@
+package peer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"handshake/common"
+	"handshake/message"
+)
+
+// socksRequest is what the SOCKS5 stand-in was asked to do
+type socksRequest struct {
+	username string
+	password string
+	host     string
+	port     uint16
+}
+
+// socksProxy is a minimal SOCKS5 server which only supports CONNECT.  It
+// resolves host names through its own table and records every request.
+type socksProxy struct {
+	listener net.Listener
+	hosts    map[string]string
+
+	mtx      sync.Mutex
+	requests []socksRequest
+}
+
+func newSOCKSProxy(t *testing.T, hosts map[string]string) *socksProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+
+	p := &socksProxy{listener: listener, hosts: hosts}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *socksProxy) Close() {
+	p.listener.Close()
+}
+
+func (p *socksProxy) Requests() []socksRequest {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return append([]socksRequest{}, p.requests...)
+}
+
+func (p *socksProxy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	req, err := p.negotiate(conn)
+	if err != nil {
+		return
+	}
+
+	p.mtx.Lock()
+	p.requests = append(p.requests, *req)
+	p.mtx.Unlock()
+
+	host, ok := p.hosts[req.host]
+	if !ok {
+		host = req.host
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(req.port))))
+	if err != nil {
+		// host unreachable
+		conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+
+	// request granted, bound to 0.0.0.0:0
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func (p *socksProxy) negotiate(conn net.Conn) (*socksRequest, error) {
+	req := &socksRequest{}
+
+	// greeting: version, number of methods, methods
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	methods := buf[:buf[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, err
+	}
+
+	method := byte(0)
+	for _, m := range methods {
+		if m == 2 {
+			method = 2
+		}
+	}
+	if _, err := conn.Write([]byte{5, method}); err != nil {
+		return nil, err
+	}
+
+	// username/password sub-negotiation
+	if method == 2 {
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		user := make([]byte, buf[1])
+		if _, err := io.ReadFull(conn, user); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return nil, err
+		}
+		pass := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, pass); err != nil {
+			return nil, err
+		}
+		req.username, req.password = string(user), string(pass)
+		if _, err := conn.Write([]byte{1, 0}); err != nil {
+			return nil, err
+		}
+	}
+
+	// connect request, go-socks always sends a domain name
+	if _, err := io.ReadFull(conn, buf[:5]); err != nil {
+		return nil, err
+	}
+	if buf[1] != 1 || buf[3] != 3 {
+		return nil, errors.New("unsupported request")
+	}
+	host := make([]byte, buf[4])
+	if _, err := io.ReadFull(conn, host); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	req.host = string(host)
+	req.port = binary.BigEndian.Uint16(buf[:2])
+
+	return req, nil
+}
+
+// failingResolver fails the test when a host name is resolved locally
+type failingResolver struct {
+	t *testing.T
+}
+
+func (r failingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.t.Errorf("host name %s resolved locally", host)
+	return nil, errors.New("local resolution not allowed")
+}
+
+func TestHandshakeThroughSOCKS5(t *testing.T) {
+	var listener *net.Listener
+	var err error
+	listener, err = mockRemotePeer()
+	if err != nil {
+		t.Fatalf("couldn't mock remote peer %+v", err)
+	}
+	defer (*listener).Close()
+
+	proxy := newSOCKSProxy(t, map[string]string{"hidden.onion": "127.0.0.1"})
+	defer proxy.Close()
+
+	cfg := DefaultHandshakeConfig()
+	cfg.Dialer = NewSOCKS5Dialer(proxy.listener.Addr().String(), "alice", "secret", false)
+	cfg.Resolver = failingResolver{t}
+
+	res, err := HandshakeWithConfig("hidden.onion", common.SimNet, ProtocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("handshake failed: %+v", err)
+	}
+	res.Conn.Close()
+
+	requests := proxy.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected one proxy request, got %+v", requests)
+	}
+	want := socksRequest{username: "alice", password: "secret", host: "hidden.onion", port: 18555}
+	if requests[0] != want {
+		t.Errorf("unexpected proxy request %+v", requests[0])
+	}
+}
+
+func TestHandshakeSOCKS5StreamIsolation(t *testing.T) {
+	var listener *net.Listener
+	var err error
+	listener, err = mockRemotePeer()
+	if err != nil {
+		t.Fatalf("couldn't mock remote peer %+v", err)
+	}
+	defer (*listener).Close()
+
+	proxy := newSOCKSProxy(t, nil)
+	defer proxy.Close()
+
+	cfg := DefaultHandshakeConfig()
+	cfg.Dialer = NewSOCKS5Dialer(proxy.listener.Addr().String(), "", "", true)
+
+	for i := 0; i < 2; i++ {
+		res, err := HandshakeWithConfig("127.0.0.1:18555", common.SimNet, ProtocolVersion, cfg)
+		if err != nil {
+			t.Fatalf("handshake failed: %+v", err)
+		}
+		res.Conn.Close()
+	}
+
+	requests := proxy.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("expected two proxy requests, got %+v", requests)
+	}
+	if requests[0].username == "" || requests[0].username == requests[1].username {
+		t.Errorf("every connection should use its own credentials: %+v", requests)
+	}
+}
+
+func TestHandshakeSOCKS5DoesNotLeakAddrMe(t *testing.T) {
+	remote, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+	defer remote.Close()
+
+	received := make(chan *message.MsgVersion, 1)
+	go func() {
+		conn, err := remote.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, msg, _, err := message.ReadMessageWithEncodingN(conn, ProtocolVersion, common.SimNet, LatestEncoding)
+		if err != nil {
+			close(received)
+			return
+		}
+		received <- msg.(*message.MsgVersion)
+	}()
+
+	proxy := newSOCKSProxy(t, map[string]string{"hidden.onion": "127.0.0.1"})
+	defer proxy.Close()
+
+	cfg := DefaultHandshakeConfig()
+	cfg.RetryPolicy = nil
+	cfg.Dialer = NewSOCKS5Dialer(proxy.listener.Addr().String(), "", "", true)
+	cfg.Resolver = failingResolver{t}
+	cfg.AddrMe = common.NetAddress{IP: net.ParseIP("203.0.113.5"), Port: 8333}
+
+	port := remote.Addr().(*net.TCPAddr).Port
+	HandshakeWithConfig("hidden.onion:"+strconv.Itoa(port), common.SimNet, ProtocolVersion, cfg)
+
+	msg := <-received
+	if msg == nil {
+		t.Fatalf("remote peer didn't receive our version")
+	}
+	if !msg.AddrMe.IP.IsUnspecified() || msg.AddrMe.Port != 0 {
+		t.Errorf("AddrMe leaked through the proxy: %+v", msg.AddrMe)
+	}
+	if !msg.AddrYou.IP.IsUnspecified() || int(msg.AddrYou.Port) != port {
+		t.Errorf("AddrYou should only carry the port of an unresolved host: %+v", msg.AddrYou)
+	}
+}
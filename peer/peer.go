@@ -1,131 +1,437 @@
 package peer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
-	"regexp"
-	"strconv"
 	"time"
 
 	"handshake/common"
 	"handshake/message"
+
+	"github.com/decred/dcrd/lru"
 )
 
-// HandshakeRetries to retry handshake after failure
+// HandshakeRetries is how many times the default retry policy attempts the
+// handshake in total
 const HandshakeRetries = 3
 
 // DefaultUserAgent for wire in the stack
 const DefaultUserAgent = "/btcwire:0.5.0/"
 
+// MinAcceptableProtocolVersion is the lowest protocol version that a
+// connected peer may support on networks whose parameters in common don't
+// tell.
+const MinAcceptableProtocolVersion = 209
+
+// NegotiationTimeout is how long we wait by default for the remote peer to
+// complete the version/verack exchange once connected
+const NegotiationTimeout = 5 * time.Second
+
+// maxSentNonces is how many of our most recent version nonces are remembered
+// to detect self connections.
+const maxSentNonces = 50
+
+// sentNonces houses the unique nonces that are generated when pushing version
+// messages that are used to detect self connections.
+var sentNonces = lru.NewCache(maxSentNonces)
+
 // LatestEncoding is the most recently specified encoding for the Bitcoin protocol
 var LatestEncoding = message.WitnessEncoding
 
 // Handshake simply retries handshake to consider network flakiness
-func Handshake(peerAddress string, network common.BitcoinNet, protocolVersion uint32) (*net.Conn, error) {
-	var err error
-	var conn *net.Conn
-	for i := 0; i < HandshakeRetries; i++ {
-		conn, err = handshake(peerAddress, network, protocolVersion)
-		if err != nil {
-			fmt.Println(err.Error())
-			continue
-		}
+func Handshake(peerAddress string, network common.BitcoinNet, protocolVersion uint32) (*HandshakeResult, error) {
+	return HandshakeWithConfig(peerAddress, network, protocolVersion, DefaultHandshakeConfig())
+}
+
+// HandshakeWithConfig is like Handshake but presents the local node described
+// by cfg to the remote peer.  A nil cfg means DefaultHandshakeConfig.
+func HandshakeWithConfig(peerAddress string, network common.BitcoinNet, protocolVersion uint32, cfg *HandshakeConfig) (*HandshakeResult, error) {
+	return HandshakeContext(context.Background(), peerAddress, network, protocolVersion, cfg)
+}
 
-		return conn, nil
+// HandshakeContext is like HandshakeWithConfig but gives up as soon as ctx is
+// done.  Any blocked dial, read or write is interrupted, the connection is
+// closed and ctx.Err() is returned.
+func HandshakeContext(ctx context.Context, peerAddress string, network common.BitcoinNet, protocolVersion uint32, cfg *HandshakeConfig) (*HandshakeResult, error) {
+	if cfg == nil {
+		cfg = DefaultHandshakeConfig()
 	}
 
-	return nil, errors.New(fmt.Sprintf("handshake failed after %d retries with the error %s", HandshakeRetries, err.Error()))
+	var errs []error
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		res, err := handshake(ctx, peerAddress, network, protocolVersion, cfg)
+		if ctx.Err() != nil {
+			// The handshake may have completed just before ctx was
+			// done, nobody gets the connection then.
+			if res != nil {
+				res.Conn.Close()
+			}
+			return nil, ctx.Err()
+		}
+
+		event := Attempt{Number: attempt, Err: err, Duration: time.Since(start)}
+		if err != nil && cfg.RetryPolicy != nil {
+			event.Delay, event.Retry = cfg.RetryPolicy.Retry(attempt, err)
+		}
+		if cfg.OnAttempt != nil {
+			cfg.OnAttempt(event)
+		}
+		if err == nil {
+			return res, nil
+		}
+		log.Debugf("Handshake attempt %d with %s failed: %v", attempt,
+			peerAddress, err)
+
+		errs = append(errs, err)
+		if !event.Retry {
+			return nil, &RetryError{Errors: errs}
+		}
+		log.Debugf("Retrying handshake with %s in %v", peerAddress, event.Delay)
+
+		timer := time.NewTimer(event.Delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
 }
 
-// handshake tipically follows the following steps:
-//
-//  1. We send our version.
-//  2. Remote peer sends their version.
-//  3. We send sendaddrv2 if their version is >= 70016.
-//  4. We send our verack.
-//  5. We wait to receive sendaddrv2 or verack, skipping unknown messages
-//  6. If sendaddrv2 was received, wait for receipt of verack.
-//
-// for the assignment purpose we skipp sendaddrv2 related checks/functionality
-// and simply send needed messages to perform handshake and establish connection.
-// For this reason we skip receiving acknowledgements. With tests we will verify
-// that the handshake succeeds by checking verack message. Obviously this function
-// is not correct/production ready but I believe for our purposes this should suffice.
-// Receiving acknowledgements will be checked in tests.
-func handshake(peerAddress string, network common.BitcoinNet, protocolVersion uint32) (*net.Conn, error) {
-	// validate address
-	pattern := `^\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}:\d+$`
-	regex := regexp.MustCompile(pattern)
-	if !regex.MatchString(peerAddress) {
-		return nil, errors.New("incorrect peer address format")
-	}
-
-	// Create a Dialer with timeout
-	dialer := &net.Dialer{
-		Timeout:   1 * time.Second, // Set the timeout to 1 seconds
-		KeepAlive: 0,
-	}
-
-	// Dial with the Dialer
-	conn, err := dialer.Dial("tcp", peerAddress)
+// handshake connects to the peer and negotiates the connection.
+func handshake(ctx context.Context, peerAddress string, network common.BitcoinNet, protocolVersion uint32, cfg *HandshakeConfig) (*HandshakeResult, error) {
+	// parse and, unless a proxy does it for us, resolve the address
+	target, addrYou, err := dialTarget(ctx, peerAddress, network, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	// Set a deadline for writes
-	err = conn.SetWriteDeadline(time.Now().Add(1 * time.Second))
+	nonce, err := cfg.nonce()
 	if err != nil {
 		return nil, err
 	}
 
-	ip, port, err := net.SplitHostPort(peerAddress)
+	// Dial with the configured dialer
+	start := time.Now()
+	dialCtx, cancel := ctx, context.CancelFunc(func() {})
+	if cfg.DialTimeout > 0 {
+		dialCtx, cancel = context.WithTimeout(ctx, cfg.DialTimeout)
+	}
+	conn, err := cfg.dialer().DialContext(dialCtx, "tcp", target)
+	cancel()
 	if err != nil {
-		conn.Close()
-		return nil, err
+		return nil, timeoutError(message.PhaseDial, err)
 	}
+	res := &HandshakeResult{Conn: conn, Network: network}
+	res.Timings.Dial = time.Since(start)
+
+	// construct version message to initiate handshake
+	localVerMsg := newLocalVersion(cfg, protocolVersion, nonce, addrYou)
 
-	// Convert port string to uint16
-	portUint64, err := strconv.ParseUint(port, 10, 16)
+	// Never tell the remote peer where we really are when proxied.
+	if resolvesRemotely(cfg.dialer()) {
+		localVerMsg.AddrMe = common.NetAddress{}
+	}
+
+	err = finishHandshake(ctx, conn, res, localVerMsg, protocolVersion, cfg, false)
 	if err != nil {
-		conn.Close()
 		return nil, err
 	}
+	res.Timings.Total = time.Since(start)
 
-	// construct version message to initiate handshake
-	localVerMsg := &message.MsgVersion{
+	return res, nil
+}
+
+// newLocalVersion constructs the version message describing the local node.
+func newLocalVersion(cfg *HandshakeConfig, protocolVersion uint32, nonce uint64, addrYou *net.TCPAddr) *message.MsgVersion {
+	return &message.MsgVersion{
 		ProtocolVersion: int32(protocolVersion),
-		Services:        0,
+		Services:        cfg.Services,
 		Timestamp:       time.Unix(time.Now().Unix(), 0),
 		AddrYou: common.NetAddress{
 			Timestamp: time.Now(),
 			Services:  0x0,
-			IP:        net.ParseIP(ip),
-			Port:      uint16(portUint64),
+			IP:        addrYou.IP,
+			Port:      uint16(addrYou.Port),
 		},
-		AddrMe:         common.NetAddress{},
-		Nonce:          1,
-		UserAgent:      DefaultUserAgent,
-		LastBlock:      0,
-		DisableRelayTx: false,
+		AddrMe:         cfg.AddrMe,
+		Nonce:          nonce,
+		UserAgent:      cfg.UserAgent,
+		LastBlock:      cfg.LastBlock,
+		DisableRelayTx: cfg.DisableRelayTx,
 	}
+}
 
-	// 1. We send our version
-	err = message.WriteMessageWithEncodingN(conn, localVerMsg, protocolVersion, network, LatestEncoding)
+// finishHandshake negotiates over an established connection and hands it over
+// to the caller once both sides exchanged verack.  The exchange is aborted as
+// soon as ctx is done and the connection is closed on any failure.
+func finishHandshake(ctx context.Context, conn net.Conn, res *HandshakeResult, localVerMsg *message.MsgVersion, protocolVersion uint32, cfg *HandshakeConfig, inbound bool) error {
+	stop := watchContext(ctx, conn)
+	err := negotiate(conn, res, localVerMsg, protocolVersion, cfg, inbound)
+	if stop() {
+		err = ctx.Err()
+	}
 	if err != nil {
 		conn.Close()
-		return nil, err
+		return err
 	}
 
-	// 4. We send our verack.
-	// At this point we skipped receiving the corresponding version
-	// message from the peer, assumed it was valid and acceptable and
-	// now return verack message to let peer know everything went ok
-	err = message.WriteMessageWithEncodingN(conn, &message.MsgVerAck{}, protocolVersion, network, LatestEncoding)
+	// The handshake is over, the caller owns the connection from here on.
+	err = conn.SetDeadline(time.Time{})
 	if err != nil {
 		conn.Close()
-		return nil, err
+		return err
 	}
+	log.Debugf("Handshake with %s (inbound %v) completed: %s, protocol "+
+		"version %d, negotiated %d", conn.RemoteAddr(), inbound,
+		res.UserAgent, res.RemoteProtocolVersion, res.NegotiatedVersion)
+
+	return nil
+}
+
+// watchContext interrupts any blocked read or write on conn once ctx is done.
+// The returned stop function ends the watch and reports whether conn was
+// interrupted.  It must be called before conn is handed over to the caller.
+func watchContext(ctx context.Context, conn net.Conn) (stop func() bool) {
+	stopc := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+			interrupted <- true
+		case <-stopc:
+			interrupted <- false
+		}
+	}()
 
-	return &conn, nil
+	return func() bool {
+		close(stopc)
+		return <-interrupted
+	}
+}
+
+// negotiate tipically follows the following steps:
+//
+//  1. We send our version.
+//  2. Remote peer sends their version.
+//  3. We send sendaddrv2 if their version is >= 70016.
+//  4. We send our verack.
+//  5. We wait to receive sendaddrv2 or verack, skipping unknown messages
+//  6. If sendaddrv2 was received, wait for receipt of verack.
+//
+// For inbound connections the first two steps are swapped: the remote peer
+// speaks first and we only answer with our version once we accepted theirs.
+//
+// It only returns nil once verack has been exchanged in both directions, so a
+// nil error means the remote peer accepted us.
+func negotiate(conn net.Conn, res *HandshakeResult, localVerMsg *message.MsgVersion, protocolVersion uint32, cfg *HandshakeConfig, inbound bool) error {
+	network := res.Network
+
+	// Set a deadline for the whole negotiation so an unresponsive peer
+	// can't stall us forever
+	if cfg.ReadTimeout > 0 {
+		err := conn.SetReadDeadline(time.Now().Add(cfg.ReadTimeout))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remember our nonce so we can detect connecting to ourselves.
+	sentNonces.Add(localVerMsg.Nonce)
+
+	var err error
+	var remoteVerMsg *message.MsgVersion
+	versionSent := time.Now()
+	if inbound {
+		// 2. Remote peer sends their version.
+		remoteVerMsg, err = readRemoteVersion(conn, network, protocolVersion, cfg)
+		if err != nil {
+			return err
+		}
+
+		// 1. We answer with our version
+		err = setWriteDeadline(conn, cfg)
+		if err != nil {
+			return err
+		}
+		err = message.WriteMessageWithEncodingN(conn, localVerMsg, protocolVersion, network, LatestEncoding)
+		if err != nil {
+			return err
+		}
+	} else {
+		// 1. We send our version
+		err = setWriteDeadline(conn, cfg)
+		if err != nil {
+			return err
+		}
+		err = message.WriteMessageWithEncodingN(conn, localVerMsg, protocolVersion, network, LatestEncoding)
+		if err != nil {
+			return err
+		}
+
+		// 2. Remote peer sends their version.
+		remoteVerMsg, err = readRemoteVersion(conn, network, protocolVersion, cfg)
+		if err != nil {
+			return err
+		}
+
+		// However long the remote peer took, the messages up to our
+		// verack get a fresh write deadline.
+		err = setWriteDeadline(conn, cfg)
+		if err != nil {
+			return err
+		}
+	}
+	versionReceived := time.Now()
+	res.Timings.Version = versionReceived.Sub(versionSent)
+	res.ClockOffset = remoteVerMsg.Timestamp.Sub(versionReceived.Truncate(time.Second))
+
+	// Both sides speak the lower of the two protocol versions from now on.
+	negotiatedVersion := protocolVersion
+	if uint32(remoteVerMsg.ProtocolVersion) < negotiatedVersion {
+		negotiatedVersion = uint32(remoteVerMsg.ProtocolVersion)
+	}
+	res.setRemoteVersion(remoteVerMsg, negotiatedVersion)
+
+	// 3. We send feature negotiation messages the negotiated version allows.
+	err = sendFeatures(conn, network, negotiatedVersion)
+	if err != nil {
+		return err
+	}
+
+	// 4. We send our verack.
+	verAckSent := time.Now()
+	err = message.WriteMessageWithEncodingN(conn, &message.MsgVerAck{}, negotiatedVersion, network, LatestEncoding)
+	if err != nil {
+		return err
+	}
+
+	// 5. and 6. We wait for verack, skipping feature and unknown messages.
+	remoteFeatures, err := waitForVerAck(conn, network, negotiatedVersion)
+	if err != nil {
+		return err
+	}
+	res.Timings.VerAck = time.Since(verAckSent)
+
+	// A feature is only agreed on when both sides announced it.
+	res.Features.AddrV2 = remoteFeatures.AddrV2 && negotiatedVersion >= message.AddrV2Version
+	res.Features.WtxidRelay = remoteFeatures.WtxidRelay && negotiatedVersion >= message.WtxidRelayVersion
+
+	return nil
+}
+
+// setWriteDeadline gives the writes that follow cfg.WriteTimeout to complete.
+func setWriteDeadline(conn net.Conn, cfg *HandshakeConfig) error {
+	if cfg.WriteTimeout <= 0 {
+		return nil
+	}
+	return conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+}
+
+// setRemoteVersion copies what the remote peer told us about itself.
+func (res *HandshakeResult) setRemoteVersion(remoteVerMsg *message.MsgVersion, negotiatedVersion uint32) {
+	res.RemoteProtocolVersion = uint32(remoteVerMsg.ProtocolVersion)
+	res.NegotiatedVersion = negotiatedVersion
+	res.UserAgent = remoteVerMsg.UserAgent
+	res.Services = remoteVerMsg.Services
+	res.StartHeight = remoteVerMsg.LastBlock
+	res.Nonce = remoteVerMsg.Nonce
+	res.RelayTx = !remoteVerMsg.DisableRelayTx
+}
+
+// readRemoteVersion reads the first message from the remote peer which must be
+// its version message and validates it.
+func readRemoteVersion(conn net.Conn, network common.BitcoinNet, protocolVersion uint32, cfg *HandshakeConfig) (*message.MsgVersion, error) {
+	_, msg, _, err := message.ReadMessageWithEncodingN(conn, protocolVersion, network, LatestEncoding)
+	if err != nil {
+		return nil, timeoutError(message.PhaseVersion, err)
+	}
+
+	remoteVerMsg, ok := msg.(*message.MsgVersion)
+	if !ok {
+		return nil, &message.ErrUnexpectedMessage{Command: msg.Command()}
+	}
+
+	minVersion := uint32(MinAcceptableProtocolVersion)
+	if params, ok := common.ParamsForNet(network); ok && params.MinProtocolVersion > 0 {
+		minVersion = params.MinProtocolVersion
+	}
+	if remoteVerMsg.ProtocolVersion < int32(minVersion) {
+		return nil, fmt.Errorf("%w: %d is lower than minimum %d", message.ErrVersionTooOld,
+			remoteVerMsg.ProtocolVersion, minVersion)
+	}
+
+	if !cfg.AllowSelfConns && sentNonces.Contains(remoteVerMsg.Nonce) {
+		return nil, message.ErrSelfConnection
+	}
+
+	if missing := cfg.RequiredServices &^ remoteVerMsg.Services; missing != 0 {
+		return nil, fmt.Errorf("%w: advertised %v, missing %v", message.ErrMissingServices,
+			remoteVerMsg.Services, missing)
+	}
+
+	return remoteVerMsg, nil
+}
+
+// sendFeatures sends the feature negotiation messages which have to be sent
+// between version and verack.
+func sendFeatures(conn net.Conn, network common.BitcoinNet, negotiatedVersion uint32) error {
+	if negotiatedVersion >= message.WtxidRelayVersion {
+		err := message.WriteMessageWithEncodingN(conn, &message.MsgWtxidRelay{}, negotiatedVersion, network, LatestEncoding)
+		if err != nil {
+			return err
+		}
+	}
+
+	if negotiatedVersion >= message.AddrV2Version {
+		err := message.WriteMessageWithEncodingN(conn, &message.MsgSendAddrV2{}, negotiatedVersion, network, LatestEncoding)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForVerAck reads messages until the remote verack arrives and returns the
+// features the remote peer announced on the way.  Unknown messages are skipped
+// while anything else is a protocol violation.
+func waitForVerAck(conn net.Conn, network common.BitcoinNet, negotiatedVersion uint32) (Features, error) {
+	var features Features
+	for {
+		_, msg, _, err := message.ReadMessageWithEncodingN(conn, negotiatedVersion, network, LatestEncoding)
+		if err == message.ErrUnknownMessage {
+			continue
+		} else if err != nil {
+			return features, timeoutError(message.PhaseVerAck, err)
+		}
+
+		switch msg.(type) {
+		case *message.MsgSendAddrV2:
+			features.AddrV2 = true
+		case *message.MsgWtxidRelay:
+			features.WtxidRelay = true
+		case *message.MsgVerAck:
+			return features, nil
+		case *message.MsgSendHeaders, *message.MsgSendCmpct, *message.MsgFeeFilter:
+			// Usually sent after verack, but harmless before.
+			continue
+		default:
+			return features, &message.ErrUnexpectedMessage{Command: msg.Command()}
+		}
+	}
+}
+
+// timeoutError turns a network timeout while waiting for phase into a
+// message.ErrHandshakeTimeout and returns any other error as is.
+func timeoutError(phase string, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &message.ErrHandshakeTimeout{Phase: phase, Err: err}
+	}
+	return err
 }
@@ -1,6 +1,8 @@
 package peer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -9,6 +11,7 @@ import (
 
 	"handshake/checker"
 	"handshake/common"
+	"handshake/message"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/peer"
@@ -37,15 +40,17 @@ func mockRemotePeer() (*net.Listener, error) {
 		return nil, err
 	}
 	go func() {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Printf("Accept: error %v\n", err)
-			return
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				fmt.Printf("Accept: error %v\n", err)
+				return
+			}
+
+			// Create and start the inbound peer.
+			p := peer.NewInboundPeer(peerCfg)
+			p.AssociateConnection(conn)
 		}
-
-		// Create and start the inbound peer.
-		p := peer.NewInboundPeer(peerCfg)
-		p.AssociateConnection(conn)
 	}()
 
 	return &listener, nil
@@ -60,19 +65,52 @@ func TestHandshakeSuccess(t *testing.T) {
 	}
 	defer (*listener).Close()
 
-	conn, err := Handshake("127.0.0.1:18555", common.SimNet, ProtocolVersion)
+	res, err := Handshake("127.0.0.1:18555", common.SimNet, ProtocolVersion)
 	if err != nil {
 		t.Fatalf("handshake failed: %+v", err)
 	}
+	defer res.Conn.Close()
 
-	err = checker.ReadMessageWithEncodingN(*conn, ProtocolVersion, common.SimNet)
+	if !strings.Contains(res.UserAgent, "peer:1.0.0") {
+		t.Errorf("unexpected remote user agent %q", res.UserAgent)
+	}
+	if res.NegotiatedVersion != ProtocolVersion {
+		t.Errorf("unexpected negotiated version %d", res.NegotiatedVersion)
+	}
+	// btcd announces addrv2 support but doesn't implement wtxidrelay
+	if !res.Features.AddrV2 || res.Features.WtxidRelay {
+		t.Errorf("unexpected negotiated features %+v", res.Features)
+	}
+	if res.Timings.Total < res.Timings.Dial+res.Timings.Version {
+		t.Errorf("inconsistent timings %+v", res.Timings)
+	}
+}
+
+func TestHandshakeThenChecker(t *testing.T) {
+	var listener *net.Listener
+	var err error
+	listener, err = mockRemotePeer()
 	if err != nil {
-		t.Fatalf("reading intermediary message before verack failed: %+v", err)
+		t.Fatalf("couldn't mock remote peer %+v", err)
 	}
+	defer (*listener).Close()
 
-	err = checker.WaitToFinishNegotiation(*conn, ProtocolVersion, common.SimNet)
+	// an old protocol version skips feature negotiation entirely
+	res, err := Handshake("127.0.0.1:18555", common.SimNet, 70002)
 	if err != nil {
-		t.Fatalf("verack message not received for the handshake: %+v", err)
+		t.Fatalf("handshake failed: %+v", err)
+	}
+	defer res.Conn.Close()
+
+	if res.NegotiatedVersion != 70002 || res.Features.AddrV2 {
+		t.Errorf("unexpected negotiation %d %+v", res.NegotiatedVersion, res.Features)
+	}
+
+	// deadlines set during the handshake must not leak to the caller
+	err = checker.WaitToFinishNegotiation(res.Conn, 70002, common.SimNet)
+	var timeoutErr *message.ErrHandshakeTimeout
+	if !errors.As(err, &timeoutErr) {
+		t.Errorf("no further verack expected after the handshake, got %+v", err)
 	}
 }
 
@@ -137,18 +175,13 @@ func TestHandshakeIncorrectProtocol(t *testing.T) {
 	}
 	defer (*listener).Close()
 
-	conn, err := Handshake("127.0.0.1:18555", common.SimNet, 123)
-	if err != nil {
-		t.Fatalf("handshake failed: %+v", err)
-	}
-
-	err = checker.ReadMessageWithEncodingN(*conn, ProtocolVersion, common.SimNet)
+	_, err = Handshake("127.0.0.1:18555", common.SimNet, 123)
 	if err == nil {
-		t.Fatalf("call should have failed because of the incorrect protocol")
+		t.Fatalf("handshake should have failed because of the incorrect protocol")
 	}
 
-	if !strings.Contains(err.Error(), "connection reset by peer") {
-		t.Errorf("connection should have been reset because of the incorrect protocol")
+	if !IsDisconnect(err) {
+		t.Errorf("connection should have been closed because of the incorrect protocol, got %+v", err)
 	}
 }
 
@@ -161,18 +194,13 @@ func TestHandshakeIncorrectNetwork(t *testing.T) {
 	}
 	defer (*listener).Close()
 
-	conn, err := Handshake("127.0.0.1:18555", common.MainNet, ProtocolVersion)
-	if err != nil {
-		t.Fatalf("handshake failed: %+v", err)
-	}
-
-	err = checker.ReadMessageWithEncodingN(*conn, ProtocolVersion, common.SimNet)
+	_, err = Handshake("127.0.0.1:18555", common.MainNet, ProtocolVersion)
 	if err == nil {
-		t.Fatalf("call should have failed because of the incorrect network parameter")
+		t.Fatalf("handshake should have failed because of the incorrect network parameter")
 	}
 
-	if !strings.Contains(err.Error(), "connection reset by peer") {
-		t.Errorf("connection should have been reset because of the incorrect network parameter")
+	if !IsDisconnect(err) {
+		t.Errorf("connection should have been closed because of the incorrect network parameter, got %+v", err)
 	}
 }
 
@@ -194,3 +222,302 @@ func TestHandshakeIncorrectAddressTimeout(t *testing.T) {
 		t.Errorf("connection should have been timed out")
 	}
 }
+
+func TestHandshakeWithConfig(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+	defer listener.Close()
+
+	// the remote side only records our version and never answers
+	received := make(chan *message.MsgVersion, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, msg, _, err := message.ReadMessageWithEncodingN(conn, ProtocolVersion, common.SimNet, LatestEncoding)
+		if err != nil {
+			close(received)
+			return
+		}
+		received <- msg.(*message.MsgVersion)
+		time.Sleep(time.Second)
+	}()
+
+	cfg := DefaultHandshakeConfig()
+	cfg.UserAgent = "/pruned:0.1/"
+	cfg.Services = 1024
+	cfg.Nonce = 42
+	cfg.LastBlock = 800000
+	cfg.DisableRelayTx = true
+	cfg.ReadTimeout = 100 * time.Millisecond
+	cfg.RetryPolicy = nil
+
+	start := time.Now()
+	_, err = HandshakeWithConfig(listener.Addr().String(), common.SimNet, ProtocolVersion, cfg)
+	var timeoutErr *message.ErrHandshakeTimeout
+	if !errors.As(err, &timeoutErr) || timeoutErr.Phase != message.PhaseVersion {
+		t.Errorf("handshake should time out waiting for the remote version, got %+v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("read timeout was not applied")
+	}
+
+	msg := <-received
+	if msg == nil {
+		t.Fatalf("remote peer didn't receive our version")
+	}
+	if msg.UserAgent != cfg.UserAgent || msg.Services != cfg.Services ||
+		msg.Nonce != cfg.Nonce || msg.LastBlock != cfg.LastBlock ||
+		msg.DisableRelayTx != cfg.DisableRelayTx {
+		t.Errorf("version message doesn't reflect the config: %+v", msg)
+	}
+}
+
+func TestHandshakeSendHeadersBeforeVerAck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+	defer listener.Close()
+
+	// the remote side asks for headers announcements before its verack,
+	// which isn't a feature negotiated in the handshake
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _, _, err = message.ReadMessageWithEncodingN(conn, ProtocolVersion, common.SimNet, LatestEncoding)
+		if err != nil {
+			return
+		}
+		remoteVerMsg := newLocalVersion(DefaultHandshakeConfig(), ProtocolVersion, 7, &net.TCPAddr{})
+		for _, msg := range []message.Message{remoteVerMsg, &message.MsgSendHeaders{}, &message.MsgVerAck{}} {
+			err = message.WriteMessageWithEncodingN(conn, msg, ProtocolVersion, common.SimNet, LatestEncoding)
+			if err != nil {
+				return
+			}
+		}
+		checker.WaitToFinishNegotiation(conn, ProtocolVersion, common.SimNet)
+	}()
+
+	cfg := DefaultHandshakeConfig()
+	cfg.AllowSelfConns = true
+	cfg.RetryPolicy = nil
+	res, err := HandshakeWithConfig(listener.Addr().String(), common.SimNet, ProtocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("handshake failed: %+v", err)
+	}
+	defer res.Conn.Close()
+
+	if res.Features != (Features{}) {
+		t.Errorf("unexpected features %+v", res.Features)
+	}
+}
+
+func TestHandshakeContextCancel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+	defer listener.Close()
+
+	// the remote side accepts but never answers
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(2 * time.Second)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = HandshakeContext(ctx, listener.Addr().String(), common.SimNet, ProtocolVersion, DefaultHandshakeConfig())
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %+v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("handshake wasn't interrupted by the cancellation")
+	}
+}
+
+// cancelConn cancels a context once the handshake hands over the connection
+// by clearing its deadlines, and records whether it was closed.
+type cancelConn struct {
+	net.Conn
+	cancel context.CancelFunc
+	closed chan struct{}
+}
+
+func (c *cancelConn) SetDeadline(t time.Time) error {
+	if t.IsZero() {
+		c.cancel()
+	}
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *cancelConn) Close() error {
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	return c.Conn.Close()
+}
+
+// cancelDialer dials connections which cancel a context on hand over.
+type cancelDialer struct {
+	cancel context.CancelFunc
+	conn   *cancelConn
+}
+
+func (d *cancelDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	d.conn = &cancelConn{Conn: conn, cancel: d.cancel, closed: make(chan struct{})}
+	return d.conn, nil
+}
+
+func TestHandshakeContextCancelAfterSuccess(t *testing.T) {
+	server := listenLocal(t, true)
+	defer server.Close()
+	accepted := acceptAsync(server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dialer := &cancelDialer{cancel: cancel}
+	cfg := DefaultHandshakeConfig()
+	cfg.AllowSelfConns = true
+	cfg.Dialer = dialer
+
+	_, err := HandshakeContext(ctx, server.Addr().String(), common.SimNet, ProtocolVersion, cfg)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %+v", err)
+	}
+	select {
+	case <-dialer.conn.closed:
+	default:
+		t.Errorf("connection of the completed handshake wasn't closed")
+	}
+
+	if inbound := <-accepted; inbound.err == nil {
+		inbound.res.Conn.Close()
+	}
+}
+
+func TestHandshakeHostnameDefaultPort(t *testing.T) {
+	var listener *net.Listener
+	var err error
+	listener, err = mockRemotePeer()
+	if err != nil {
+		t.Fatalf("couldn't mock remote peer %+v", err)
+	}
+	defer (*listener).Close()
+
+	cfg := DefaultHandshakeConfig()
+	cfg.Resolver = staticResolver{
+		"seed.example": {{IP: net.ParseIP("127.0.0.1")}},
+	}
+
+	// no port given, so the simnet default port of the mock is used
+	res, err := HandshakeWithConfig("seed.example", common.SimNet, ProtocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("handshake failed: %+v", err)
+	}
+	res.Conn.Close()
+}
+
+func TestHandshakeIPv6AddrYou(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan *message.MsgVersion, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, msg, _, err := message.ReadMessageWithEncodingN(conn, ProtocolVersion, common.SimNet, LatestEncoding)
+		if err != nil {
+			close(received)
+			return
+		}
+		received <- msg.(*message.MsgVersion)
+	}()
+
+	cfg := DefaultHandshakeConfig()
+	cfg.RetryPolicy = nil
+	HandshakeWithConfig(listener.Addr().String(), common.SimNet, ProtocolVersion, cfg)
+
+	msg := <-received
+	if msg == nil {
+		t.Fatalf("remote peer didn't receive our version")
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	if !msg.AddrYou.IP.Equal(net.IPv6loopback) || int(msg.AddrYou.Port) != port {
+		t.Errorf("unexpected AddrYou %+v", msg.AddrYou)
+	}
+}
+
+// respondLate runs the handshake with our own responder on conn after waiting
+// for delay, like a remote node busy with other peers.
+func respondLate(conn net.Conn, delay time.Duration, inbound bool) error {
+	time.Sleep(delay)
+
+	cfg := DefaultHandshakeConfig()
+	cfg.AllowSelfConns = true
+	res := &HandshakeResult{Conn: conn, Network: common.SimNet}
+	localVerMsg := newLocalVersion(cfg, ProtocolVersion, 7, &net.TCPAddr{})
+	return finishHandshake(context.Background(), conn, res, localVerMsg, ProtocolVersion, cfg, inbound)
+}
+
+func TestHandshakeSlowRemoteVersion(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+	defer listener.Close()
+
+	remoteErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			remoteErr <- err
+			return
+		}
+		defer conn.Close()
+		remoteErr <- respondLate(conn, 300*time.Millisecond, true)
+	}()
+
+	// the remote version arrives well after the write timeout expired
+	cfg := DefaultHandshakeConfig()
+	cfg.WriteTimeout = 100 * time.Millisecond
+	cfg.AllowSelfConns = true
+	cfg.RetryPolicy = nil
+	res, err := HandshakeWithConfig(listener.Addr().String(), common.SimNet, ProtocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("handshake failed: %+v", err)
+	}
+	defer res.Conn.Close()
+
+	if err := <-remoteErr; err != nil {
+		t.Errorf("remote side failed: %+v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package peer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"handshake/common"
+	"handshake/message"
+)
+
+// ErrPingTimeout is the disconnect reason of a peer which didn't answer a
+// keepalive ping in time.
+var ErrPingTimeout = errors.New("ping timeout")
+
+// PingStats summarizes the round trip times measured with ping/pong.  All
+// durations are zero until the first pong arrives.
+type PingStats struct {
+	// Last is the round trip time of the most recent pong.
+	Last time.Duration
+
+	// Min is the fastest round trip time seen.
+	Min time.Duration
+
+	// Avg is the mean round trip time over all pongs.
+	Avg time.Duration
+
+	// Count is the number of pongs matched to a ping.
+	Count int
+}
+
+// pingState tracks the outstanding keepalive ping and the round trip times of
+// the answered ones.
+type pingState struct {
+	mtx      sync.Mutex
+	pending  bool
+	nonce    uint64
+	sent     time.Time
+	total    time.Duration
+	stats    PingStats
+	received chan struct{}
+}
+
+// KeepAlive makes the peer send a ping every interval once started and
+// disconnect with ErrPingTimeout when the matching pong doesn't arrive within
+// timeout.  Peers which negotiated a version without pong (BIP0031) are only
+// pinged.  It must be called before Start.
+func (p *Peer) KeepAlive(interval, timeout time.Duration) {
+	p.pingInterval = interval
+	p.pingTimeout = timeout
+}
+
+// PingStats returns the round trip times measured so far.
+func (p *Peer) PingStats() PingStats {
+	p.ping.mtx.Lock()
+	defer p.ping.mtx.Unlock()
+	return p.ping.stats
+}
+
+// handlePing answers a ping with a pong carrying the same nonce.  There is no
+// pong before BIP0031.
+func (p *Peer) handlePing(msg *message.MsgPing) {
+	if p.res.NegotiatedVersion <= message.BIP0031Version {
+		return
+	}
+	p.QueueMessage(&message.MsgPong{Nonce: msg.Nonce}, nil)
+}
+
+// handlePong records the round trip time when msg answers the outstanding
+// ping.  Pongs with any other nonce are ignored.
+func (p *Peer) handlePong(msg *message.MsgPong) {
+	s := &p.ping
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if !s.pending || msg.Nonce != s.nonce {
+		return
+	}
+	s.pending = false
+
+	rtt := time.Since(s.sent)
+	s.total += rtt
+	s.stats.Count++
+	s.stats.Last = rtt
+	s.stats.Avg = s.total / time.Duration(s.stats.Count)
+	if s.stats.Min == 0 || rtt < s.stats.Min {
+		s.stats.Min = rtt
+	}
+	log.Tracef("Pong from %s after %v", p.res.Conn.RemoteAddr(), rtt)
+
+	select {
+	case s.received <- struct{}{}:
+	default:
+	}
+}
+
+// pingLoop sends a ping every interval and waits for the pong until the peer
+// disconnects.
+func (p *Peer) pingLoop() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.pingInterval)
+	defer ticker.Stop()
+
+	expectPong := p.res.NegotiatedVersion > message.BIP0031Version
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+
+		nonce, err := common.RandomUint64()
+		if err != nil {
+			p.disconnect(err)
+			return
+		}
+
+		if expectPong {
+			p.ping.mtx.Lock()
+			p.ping.pending = true
+			p.ping.nonce = nonce
+			p.ping.sent = time.Now()
+			p.ping.mtx.Unlock()
+		}
+		p.QueueMessage(&message.MsgPing{Nonce: nonce}, nil)
+
+		if !expectPong || p.pingTimeout <= 0 {
+			continue
+		}
+
+		timer := time.NewTimer(p.pingTimeout)
+		select {
+		case <-p.ping.received:
+			timer.Stop()
+		case <-timer.C:
+			p.disconnect(ErrPingTimeout)
+			return
+		case <-p.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package peer
+
+import (
+	"net"
+	"time"
+
+	"handshake/common"
+)
+
+// HandshakeResult describes the session negotiated with a remote peer.
+type HandshakeResult struct {
+	// Conn is the established connection.  The caller owns it and is
+	// responsible for closing it.
+	Conn net.Conn
+
+	// Network the handshake was performed on.
+	Network common.BitcoinNet
+
+	// RemoteProtocolVersion is the protocol version advertised by the peer.
+	RemoteProtocolVersion uint32
+
+	// NegotiatedVersion is the minimum of our and the remote protocol
+	// version and is used for all messages after the version exchange.
+	NegotiatedVersion uint32
+
+	// UserAgent advertised by the remote peer.
+	UserAgent string
+
+	// Services advertised by the remote peer.
+	Services common.ServiceFlag
+
+	// StartHeight is the last block the remote peer had when connecting.
+	StartHeight int32
+
+	// Nonce the remote peer used in its version message.
+	Nonce uint64
+
+	// RelayTx tells whether the remote peer wants transactions relayed to
+	// it (BIP37).
+	RelayTx bool
+
+	// Features agreed on during the handshake.
+	Features Features
+
+	// ClockOffset is the remote peer timestamp minus our local time at the
+	// moment its version message was received.
+	ClockOffset time.Duration
+
+	// Timings of each handshake phase.
+	Timings Timings
+}
+
+// Features lists the optional protocol features agreed on with the peer.
+type Features struct {
+	// AddrV2 is set when both sides sent sendaddrv2 (BIP155).
+	AddrV2 bool
+
+	// WtxidRelay is set when both sides sent wtxidrelay (BIP339).
+	WtxidRelay bool
+}
+
+// Timings holds how long each phase of the handshake took.
+type Timings struct {
+	// Dial is the time spent establishing the connection.
+	Dial time.Duration
+
+	// Version is the time from sending our version until the remote
+	// version arrived.
+	Version time.Duration
+
+	// VerAck is the time from sending our verack until the remote verack
+	// arrived.
+	VerAck time.Duration
+
+	// Total is the time spent on the whole handshake, dial included.
+	Total time.Duration
+}
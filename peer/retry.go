@@ -0,0 +1,169 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"handshake/message"
+)
+
+// RetryPolicy decides whether a failed handshake attempt is retried.
+type RetryPolicy interface {
+	// Retry is called after attempt, counting from 1, failed with err.  It
+	// returns whether to try again and how long to wait before doing so.
+	Retry(attempt int, err error) (time.Duration, bool)
+}
+
+// ExponentialBackoff is a RetryPolicy waiting exponentially longer between
+// attempts.  Only errors classified as retryable are retried.
+type ExponentialBackoff struct {
+	// MaxAttempts is how many times the handshake is attempted in total.
+	MaxAttempts int
+
+	// InitialDelay is the wait before the second attempt.
+	InitialDelay time.Duration
+
+	// MaxDelay caps the wait between attempts.  Zero means no cap.
+	MaxDelay time.Duration
+
+	// Multiplier grows the delay after every attempt.  Values below 1
+	// mean 2.
+	Multiplier float64
+
+	// Jitter randomizes every delay by up to this fraction in either
+	// direction, so peers retrying together spread out.
+	Jitter float64
+
+	// Retryable classifies errors.  IsRetryable is used when it is nil.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy returns the policy used by Handshake.
+func DefaultRetryPolicy() *ExponentialBackoff {
+	return &ExponentialBackoff{
+		MaxAttempts:  HandshakeRetries,
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     2 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.2,
+	}
+}
+
+// Retry implements RetryPolicy.
+func (b *ExponentialBackoff) Retry(attempt int, err error) (time.Duration, bool) {
+	if attempt >= b.MaxAttempts {
+		return 0, false
+	}
+
+	retryable := b.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	if !retryable(err) {
+		return 0, false
+	}
+
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	delay := float64(b.InitialDelay) * math.Pow(multiplier, float64(attempt-1))
+	if b.Jitter > 0 {
+		delay *= 1 + b.Jitter*(2*rand.Float64()-1)
+	}
+	if b.MaxDelay > 0 && delay > float64(b.MaxDelay) {
+		delay = float64(b.MaxDelay)
+	}
+
+	return time.Duration(delay), true
+}
+
+// IsRetryable reports whether err is a transient failure, such as a timeout or
+// a dropped connection, which may go away when trying again.  Malformed
+// addresses and peers refusing what we negotiate are permanent.
+func IsRetryable(err error) bool {
+	var unexpected *message.ErrUnexpectedMessage
+	var timeout *message.ErrHandshakeTimeout
+	var dnsErr *net.DNSError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, ErrInvalidAddress),
+		errors.Is(err, message.ErrVersionTooOld),
+		errors.Is(err, message.ErrSelfConnection),
+		errors.Is(err, message.ErrMissingServices),
+		errors.Is(err, message.ErrBadMagic),
+		errors.Is(err, message.ErrPayloadTooLarge),
+		errors.As(err, &unexpected):
+		return false
+
+	case errors.As(err, &timeout),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE):
+		return true
+
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+
+	return false
+}
+
+// IsDisconnect reports whether err means the remote peer closed the
+// connection, whether noticed while reading or writing.
+func IsDisconnect(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// Attempt describes a single handshake attempt reported to
+// HandshakeConfig.OnAttempt.
+type Attempt struct {
+	// Number counts the attempts from 1.
+	Number int
+
+	// Err is why the attempt failed, nil when it succeeded.
+	Err error
+
+	// Duration is how long the attempt took.
+	Duration time.Duration
+
+	// Retry tells whether another attempt follows after Delay.
+	Retry bool
+	Delay time.Duration
+}
+
+// RetryError is returned when every handshake attempt failed.  It keeps the
+// error of each attempt, so errors.Is and errors.As match any of them.
+type RetryError struct {
+	Errors []error
+}
+
+// Error returns the error as a human-readable string.
+func (e *RetryError) Error() string {
+	errs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err.Error()
+	}
+	return fmt.Sprintf("handshake failed after %d attempts: %s",
+		len(e.Errors), strings.Join(errs, "; "))
+}
+
+// Unwrap returns the error of every attempt.
+func (e *RetryError) Unwrap() []error {
+	return e.Errors
+}
@@ -0,0 +1,135 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"handshake/common"
+	"handshake/message"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("%w: %q", ErrInvalidAddress, "::"), false},
+		{fmt.Errorf("%w: 1 is lower than minimum 209", message.ErrVersionTooOld), false},
+		{message.ErrSelfConnection, false},
+		{fmt.Errorf("%w: advertised none, missing witness", message.ErrMissingServices), false},
+		{&message.ErrUnexpectedMessage{Command: "getdata"}, false},
+		{&message.ErrHandshakeTimeout{Phase: message.PhaseVerAck}, true},
+		{io.EOF, true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+		{errors.New("something else"), false},
+	}
+
+	for _, test := range tests {
+		if got := IsRetryable(test.err); got != test.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	policy := &ExponentialBackoff{
+		MaxAttempts:  5,
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     300 * time.Millisecond,
+	}
+
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, delay := range want {
+		got, retry := policy.Retry(i+1, io.EOF)
+		if !retry || got != delay {
+			t.Errorf("attempt %d: got %v %v, want %v true", i+1, got, retry, delay)
+		}
+	}
+	if _, retry := policy.Retry(5, io.EOF); retry {
+		t.Errorf("no retry expected after the last attempt")
+	}
+	if _, retry := policy.Retry(1, message.ErrSelfConnection); retry {
+		t.Errorf("permanent errors shouldn't be retried")
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		got, _ := policy.Retry(1, io.EOF)
+		if got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("jittered delay %v out of range", got)
+		}
+	}
+}
+
+func TestHandshakeRetries(t *testing.T) {
+	// grab a free port and close it again so dialing it is refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	var attempts []Attempt
+	cfg := DefaultHandshakeConfig()
+	cfg.RetryPolicy = &ExponentialBackoff{MaxAttempts: 3, InitialDelay: time.Millisecond}
+	cfg.OnAttempt = func(a Attempt) {
+		attempts = append(attempts, a)
+	}
+
+	_, err = HandshakeWithConfig(addr, common.SimNet, ProtocolVersion, cfg)
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || len(retryErr.Errors) != 3 {
+		t.Fatalf("expected the errors of 3 attempts, got %+v", err)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("connection refusal should be kept, got %+v", err)
+	}
+
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempt events, got %d", len(attempts))
+	}
+	for i, a := range attempts {
+		if a.Number != i+1 || a.Err == nil || a.Retry != (i < 2) {
+			t.Errorf("unexpected attempt event %+v", a)
+		}
+	}
+}
+
+func TestHandshakePermanentError(t *testing.T) {
+	attempts := 0
+	cfg := DefaultHandshakeConfig()
+	cfg.OnAttempt = func(Attempt) {
+		attempts++
+	}
+
+	_, err := HandshakeWithConfig("[::1", common.SimNet, ProtocolVersion, cfg)
+	if !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("expected ErrInvalidAddress, got %+v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("invalid address shouldn't be retried, got %d attempts", attempts)
+	}
+}
+
+func TestHandshakeBackoffContext(t *testing.T) {
+	cfg := DefaultHandshakeConfig()
+	cfg.RetryPolicy = &ExponentialBackoff{MaxAttempts: 2, InitialDelay: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := HandshakeContext(ctx, "127.0.0.1:1", common.SimNet, ProtocolVersion, cfg)
+	if err != context.DeadlineExceeded {
+		t.Errorf("backoff should stop on ctx, got %+v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"handshake/common"
+)
+
+// RejectError is returned by Server.Accept when an inbound connection failed
+// the handshake.  The connection has been closed and the server keeps
+// accepting others.
+type RejectError struct {
+	// Addr is the remote address of the rejected connection.
+	Addr net.Addr
+
+	// Err is the reason the handshake failed.
+	Err error
+}
+
+// Error returns a human-readable description of the rejection.
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("rejected inbound peer %v: %v", e.Addr, e.Err)
+}
+
+// Unwrap returns the reason the handshake failed.
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// MaxPendingPeers bounds the inbound connections a Server holds at once, both
+// handshaking and handshaked but not yet taken by Accept.  Once reached, new
+// connections wait in the listen backlog until Accept is called.
+const MaxPendingPeers = 125
+
+// maxPendingRejects is how many rejections are kept for Accept.  Further
+// rejections are only logged, the connections are closed either way.
+const maxPendingRejects = 16
+
+// acceptResult is the outcome of a single inbound handshake.
+type acceptResult struct {
+	res *HandshakeResult
+	err error
+}
+
+// Server accepts inbound connections and runs the responder side of the
+// handshake on each of them concurrently.
+type Server struct {
+	listener        net.Listener
+	network         common.BitcoinNet
+	protocolVersion uint32
+	cfg             *HandshakeConfig
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	results chan acceptResult
+	rejects chan acceptResult
+	wg      sync.WaitGroup
+
+	// slots holds a token for every connection counted against
+	// MaxPendingPeers.
+	slots chan struct{}
+}
+
+// Listen announces on the local tcp address and returns a Server performing
+// handshakes on the given network.  cfg describes the local node just like for
+// outbound handshakes, a nil cfg means DefaultHandshakeConfig.
+func Listen(address string, network common.BitcoinNet, protocolVersion uint32, cfg *HandshakeConfig) (*Server, error) {
+	if cfg == nil {
+		cfg = DefaultHandshakeConfig()
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		listener:        listener,
+		network:         network,
+		protocolVersion: protocolVersion,
+		cfg:             cfg,
+		ctx:             ctx,
+		cancel:          cancel,
+		results:         make(chan acceptResult),
+		rejects:         make(chan acceptResult, maxPendingRejects),
+		slots:           make(chan struct{}, MaxPendingPeers),
+	}
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Accept waits for the next inbound peer that completed the handshake.  A
+// *RejectError means only that connection failed and Accept can be called
+// again.  Rejections don't wait for Accept, so only the first few pending
+// ones are reported.  Any other error, e.g. net.ErrClosed after Close, is
+// final.
+func (s *Server) Accept() (*HandshakeResult, error) {
+	select {
+	case r := <-s.results:
+		if r.res != nil {
+			<-s.slots
+		}
+		return r.res, r.err
+	case r := <-s.rejects:
+		return r.res, r.err
+	case <-s.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops listening, aborts the handshakes in progress and waits for them
+// to return.  Peers already handed out by Accept are not affected.
+func (s *Server) Close() error {
+	s.cancel()
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// acceptLoop accepts connections until the listener is closed and spawns a
+// handshake for each of them.
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		// Wait for a slot so neither handshakes nor peers nobody
+		// accepted yet pile up.
+		select {
+		case s.slots <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+
+		conn, err := s.listener.Accept()
+		if err != nil {
+			<-s.slots
+
+			// The listener is unusable, report why unless we closed
+			// it ourselves and shut down.
+			if s.ctx.Err() == nil {
+				s.deliver(acceptResult{err: err})
+				s.cancel()
+			}
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			r := s.handshake(conn)
+			if r.err != nil {
+				<-s.slots
+				s.reject(r)
+				return
+			}
+			s.deliver(r)
+		}()
+	}
+}
+
+// deliver hands r to Accept, closing the connection and freeing its slot if
+// the server is closed before anybody takes it.
+func (s *Server) deliver(r acceptResult) {
+	select {
+	case s.results <- r:
+	case <-s.ctx.Done():
+		if r.res != nil {
+			r.res.Conn.Close()
+			<-s.slots
+		}
+	}
+}
+
+// reject hands the rejection r to Accept unless too many rejections are
+// pending already.  The connection has been closed, so nothing waits on
+// Accept either way.
+func (s *Server) reject(r acceptResult) {
+	select {
+	case s.rejects <- r:
+	default:
+		log.Debugf("Dropping rejection, %d are pending: %v",
+			maxPendingRejects, r.err)
+	}
+}
+
+// handshake runs the responder side of the handshake on an inbound connection.
+func (s *Server) handshake(conn net.Conn) acceptResult {
+	start := time.Now()
+	res := &HandshakeResult{Conn: conn, Network: s.network}
+
+	nonce, err := s.cfg.nonce()
+	if err != nil {
+		conn.Close()
+		return acceptResult{err: &RejectError{Addr: conn.RemoteAddr(), Err: err}}
+	}
+
+	addrYou, _ := conn.RemoteAddr().(*net.TCPAddr)
+	if addrYou == nil {
+		addrYou = &net.TCPAddr{}
+	}
+	localVerMsg := newLocalVersion(s.cfg, s.protocolVersion, nonce, addrYou)
+
+	err = finishHandshake(s.ctx, conn, res, localVerMsg, s.protocolVersion, s.cfg, true)
+	if err != nil {
+		log.Debugf("Rejected inbound peer %s: %v", conn.RemoteAddr(), err)
+		return acceptResult{err: &RejectError{Addr: conn.RemoteAddr(), Err: err}}
+	}
+	res.Timings.Total = time.Since(start)
+
+	return acceptResult{res: res}
+}
@@ -0,0 +1,224 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"handshake/common"
+	"handshake/message"
+)
+
+// listenLocal starts a simnet server on a random local port
+func listenLocal(t *testing.T, allowSelfConns bool) *Server {
+	cfg := DefaultHandshakeConfig()
+	cfg.UserAgent = "/server:0.1/"
+	cfg.AllowSelfConns = allowSelfConns
+
+	server, err := Listen("127.0.0.1:0", common.SimNet, ProtocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+	return server
+}
+
+// acceptAsync runs a single Accept in the background
+func acceptAsync(server *Server) chan acceptResult {
+	accepted := make(chan acceptResult, 1)
+	go func() {
+		res, err := server.Accept()
+		accepted <- acceptResult{res, err}
+	}()
+	return accepted
+}
+
+func TestServerHandshake(t *testing.T) {
+	server := listenLocal(t, true)
+	defer server.Close()
+	accepted := acceptAsync(server)
+
+	cfg := DefaultHandshakeConfig()
+	cfg.UserAgent = "/client:0.1/"
+	cfg.AllowSelfConns = true
+	res, err := HandshakeWithConfig(server.Addr().String(), common.SimNet, ProtocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("handshake failed: %+v", err)
+	}
+	defer res.Conn.Close()
+
+	inbound := <-accepted
+	if inbound.err != nil {
+		t.Fatalf("server rejected the peer: %+v", inbound.err)
+	}
+	defer inbound.res.Conn.Close()
+
+	if res.UserAgent != "/server:0.1/" || inbound.res.UserAgent != "/client:0.1/" {
+		t.Errorf("unexpected user agents %q and %q", res.UserAgent, inbound.res.UserAgent)
+	}
+	want := Features{AddrV2: true, WtxidRelay: true}
+	if res.Features != want || inbound.res.Features != want {
+		t.Errorf("unexpected features %+v and %+v", res.Features, inbound.res.Features)
+	}
+}
+
+func TestServerRejects(t *testing.T) {
+	tests := []struct {
+		name            string
+		network         common.BitcoinNet
+		protocolVersion uint32
+		allowSelfConns  bool
+		reason          error
+	}{
+		{"wrong network", common.MainNet, ProtocolVersion, true, message.ErrBadMagic},
+		{"old protocol", common.SimNet, 106, true, message.ErrVersionTooOld},
+		{"self connection", common.SimNet, ProtocolVersion, false, message.ErrSelfConnection},
+	}
+
+	for _, test := range tests {
+		server := listenLocal(t, test.allowSelfConns)
+		accepted := acceptAsync(server)
+
+		cfg := DefaultHandshakeConfig()
+		cfg.RetryPolicy = nil
+		cfg.AllowSelfConns = true
+		_, err := HandshakeWithConfig(server.Addr().String(), test.network, test.protocolVersion, cfg)
+		if err == nil {
+			t.Errorf("%s: handshake should have failed", test.name)
+		}
+
+		inbound := <-accepted
+		var rejectErr *RejectError
+		if !errors.As(inbound.err, &rejectErr) {
+			t.Errorf("%s: expected a RejectError, got %+v", test.name, inbound.err)
+		} else if !errors.Is(rejectErr.Err, test.reason) {
+			t.Errorf("%s: unexpected reason %+v", test.name, rejectErr.Err)
+		}
+		server.Close()
+	}
+}
+
+func TestServerSlowRemoteVersion(t *testing.T) {
+	cfg := DefaultHandshakeConfig()
+	cfg.WriteTimeout = 100 * time.Millisecond
+	cfg.AllowSelfConns = true
+	server, err := Listen("127.0.0.1:0", common.SimNet, ProtocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+	defer server.Close()
+	accepted := acceptAsync(server)
+
+	// our version arrives well after the write timeout expired
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("couldn't connect %+v", err)
+	}
+	defer conn.Close()
+	if err := respondLate(conn, 300*time.Millisecond, false); err != nil {
+		t.Fatalf("handshake failed: %+v", err)
+	}
+
+	inbound := <-accepted
+	if inbound.err != nil {
+		t.Fatalf("server rejected the peer: %+v", inbound.err)
+	}
+	inbound.res.Conn.Close()
+}
+
+func TestServerRejectsWithoutAccept(t *testing.T) {
+	server := listenLocal(t, true)
+	defer server.Close()
+	goroutines := runtime.NumGoroutine()
+
+	// a flood of bad handshakes while nobody calls Accept
+	for i := 0; i < 3*maxPendingRejects; i++ {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatalf("couldn't connect %+v", err)
+		}
+		conn.Write(make([]byte, message.MessageHeaderSize))
+		conn.Close()
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("rejected handshakes left %d goroutines behind", n-goroutines)
+	}
+
+	// the first rejections are still reported
+	_, err := server.Accept()
+	if !errors.Is(err, message.ErrBadMagic) {
+		t.Errorf("expected a rejection for the bad magic, got %+v", err)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	server := listenLocal(t, true)
+	accepted := acceptAsync(server)
+
+	server.Close()
+	if r := <-accepted; !errors.Is(r.err, net.ErrClosed) {
+		t.Errorf("expected net.ErrClosed, got %+v", r.err)
+	}
+}
+
+func TestRequiredServices(t *testing.T) {
+	cfg := DefaultHandshakeConfig()
+	cfg.Services = common.SFNodeNetwork | common.SFNodeWitness
+	cfg.RequiredServices = common.SFNodeWitness
+	cfg.AllowSelfConns = true
+	server, err := Listen("127.0.0.1:0", common.SimNet, ProtocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+	defer server.Close()
+
+	// The server advertises what the client requires and the client what
+	// the server requires.
+	accepted := acceptAsync(server)
+	clientCfg := DefaultHandshakeConfig()
+	clientCfg.RetryPolicy = nil
+	clientCfg.AllowSelfConns = true
+	clientCfg.Services = common.SFNodeWitness
+	clientCfg.RequiredServices = common.SFNodeNetwork | common.SFNodeWitness
+	res, err := HandshakeWithConfig(server.Addr().String(), common.SimNet, ProtocolVersion, clientCfg)
+	if err != nil {
+		t.Fatalf("handshake failed: %+v", err)
+	}
+	res.Conn.Close()
+	if inbound := <-accepted; inbound.err != nil {
+		t.Fatalf("server rejected the peer: %+v", inbound.err)
+	} else {
+		inbound.res.Conn.Close()
+	}
+	if res.Services != cfg.Services {
+		t.Errorf("unexpected services %v", res.Services)
+	}
+
+	// The client misses what the server requires.
+	accepted = acceptAsync(server)
+	clientCfg.Services = common.SFNodeNetwork
+	clientCfg.RequiredServices = 0
+	HandshakeWithConfig(server.Addr().String(), common.SimNet, ProtocolVersion, clientCfg)
+	inbound := <-accepted
+	if !errors.Is(inbound.err, message.ErrMissingServices) {
+		t.Errorf("expected missing services, got %+v", inbound.err)
+	}
+
+	// The server misses what the client requires.
+	accepted = acceptAsync(server)
+	clientCfg.Services = common.SFNodeWitness
+	clientCfg.RequiredServices = common.SFNodeCompactFilters
+	_, err = HandshakeWithConfig(server.Addr().String(), common.SimNet, ProtocolVersion, clientCfg)
+	if !errors.Is(err, message.ErrMissingServices) {
+		t.Errorf("expected missing services, got %+v", err)
+	}
+	if inbound := <-accepted; inbound.err == nil {
+		inbound.res.Conn.Close()
+	}
+}
@@ -0,0 +1,253 @@
+package peer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"handshake/message"
+)
+
+// outputBufferSize is the number of queued messages a peer accepts before
+// QueueMessage blocks.
+const outputBufferSize = 50
+
+// ErrPeerDisconnected is the disconnect reason of a peer disconnected locally
+// and the error reported for messages queued once it is gone.
+var ErrPeerDisconnected = errors.New("peer disconnected")
+
+// ErrAddrV2NotNegotiated is reported when queueing an addrv2 message for a
+// peer which didn't announce sendaddrv2 during the handshake (BIP155).
+var ErrAddrV2NotNegotiated = errors.New("addrv2 not negotiated with peer")
+
+// MessageHandler is invoked from the read loop of a peer for every message of
+// the command it is registered for.  Handlers run one at a time, so a slow
+// handler delays reading the next message.
+type MessageHandler func(p *Peer, msg message.Message)
+
+// outMsg is a message waiting in the send queue.
+type outMsg struct {
+	msg  message.Message
+	done chan<- error
+}
+
+// Peer owns a connection after a successful handshake.  It decodes incoming
+// messages and dispatches them to the registered handlers, and writes queued
+// messages one at a time.
+type Peer struct {
+	res *HandshakeResult
+
+	handlersMtx sync.RWMutex
+	handlers    map[string]MessageHandler
+
+	// queueMtx makes sure nothing is queued once the queue was drained.
+	queueMtx    sync.Mutex
+	queueClosed bool
+	outgoing    chan outMsg
+
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+	ping         pingState
+
+	quit      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	quitOnce  sync.Once
+	wg        sync.WaitGroup
+
+	reasonMtx sync.Mutex
+	reason    error
+}
+
+// NewPeer takes over the connection of a completed handshake.  Handlers should
+// be registered before calling Start so no message is missed.
+func NewPeer(res *HandshakeResult) *Peer {
+	return &Peer{
+		res:      res,
+		handlers: make(map[string]MessageHandler),
+		outgoing: make(chan outMsg, outputBufferSize),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		ping:     pingState{received: make(chan struct{}, 1)},
+	}
+}
+
+// Result returns what was negotiated during the handshake.
+func (p *Peer) Result() *HandshakeResult {
+	return p.res
+}
+
+// Handle registers handler for messages with the given command, replacing any
+// previous handler.  Messages without a handler are dropped.  Pings are always
+// answered and pongs always recorded before the handler runs.
+func (p *Peer) Handle(command string, handler MessageHandler) {
+	p.handlersMtx.Lock()
+	p.handlers[command] = handler
+	p.handlersMtx.Unlock()
+}
+
+// Start begins processing incoming and outgoing messages.  It does nothing
+// once the peer is disconnected.
+func (p *Peer) Start() {
+	p.startOnce.Do(func() {
+		p.wg.Add(2)
+		go p.readLoop()
+		go p.writeLoop()
+		if p.pingInterval > 0 {
+			p.wg.Add(1)
+			go p.pingLoop()
+		}
+
+		go func() {
+			p.wg.Wait()
+			close(p.done)
+		}()
+	})
+}
+
+// QueueMessage adds msg to the send queue.  The result of writing it is sent
+// on done when it is non-nil, which must then have room for one value.
+func (p *Peer) QueueMessage(msg message.Message, done chan<- error) {
+	if _, ok := msg.(*message.MsgAddrV2); ok && !p.res.Features.AddrV2 {
+		if done != nil {
+			done <- ErrAddrV2NotNegotiated
+		}
+		return
+	}
+
+	p.queueMtx.Lock()
+	defer p.queueMtx.Unlock()
+
+	if p.queueClosed {
+		if done != nil {
+			done <- ErrPeerDisconnected
+		}
+		return
+	}
+
+	select {
+	case p.outgoing <- outMsg{msg: msg, done: done}:
+	case <-p.quit:
+		if done != nil {
+			done <- ErrPeerDisconnected
+		}
+	}
+}
+
+// Disconnect closes the connection and stops the peer.  Done is closed once
+// both loops have returned.
+func (p *Peer) Disconnect() {
+	p.disconnect(ErrPeerDisconnected)
+}
+
+// Done returns a channel which is closed once the peer is disconnected and
+// its loops have returned.
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
+}
+
+// DisconnectReason returns why the peer got disconnected, nil while it is
+// still connected.  It is ErrPeerDisconnected after Disconnect or the error
+// which broke the connection otherwise.
+func (p *Peer) DisconnectReason() error {
+	p.reasonMtx.Lock()
+	defer p.reasonMtx.Unlock()
+	return p.reason
+}
+
+// disconnect records the first reason and tears the connection down.
+func (p *Peer) disconnect(reason error) {
+	p.quitOnce.Do(func() {
+		log.Debugf("Disconnecting %s: %v", p.res.Conn.RemoteAddr(), reason)
+
+		p.reasonMtx.Lock()
+		p.reason = reason
+		p.reasonMtx.Unlock()
+
+		close(p.quit)
+		p.res.Conn.Close()
+
+		// Without loops nobody else closes done, and Start must not
+		// start them anymore.
+		p.startOnce.Do(func() {
+			p.drainQueue()
+			close(p.done)
+		})
+	})
+}
+
+// readLoop decodes incoming messages and dispatches them to the handlers until
+// the connection breaks.
+func (p *Peer) readLoop() {
+	defer p.wg.Done()
+
+	for {
+		_, msg, _, err := message.ReadMessageWithEncodingN(p.res.Conn,
+			p.res.NegotiatedVersion, p.res.Network, LatestEncoding)
+		if err == message.ErrUnknownMessage {
+			continue
+		} else if err != nil {
+			p.disconnect(err)
+			return
+		}
+
+		switch m := msg.(type) {
+		case *message.MsgPing:
+			p.handlePing(m)
+		case *message.MsgPong:
+			p.handlePong(m)
+		}
+
+		p.handlersMtx.RLock()
+		handler := p.handlers[msg.Command()]
+		p.handlersMtx.RUnlock()
+
+		if handler != nil {
+			handler(p, msg)
+		}
+	}
+}
+
+// writeLoop writes queued messages one at a time until the peer disconnects.
+func (p *Peer) writeLoop() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case out := <-p.outgoing:
+			err := message.WriteMessageWithEncodingN(p.res.Conn, out.msg,
+				p.res.NegotiatedVersion, p.res.Network, LatestEncoding)
+			if out.done != nil {
+				out.done <- err
+			}
+			if err != nil {
+				p.disconnect(err)
+				p.drainQueue()
+				return
+			}
+
+		case <-p.quit:
+			p.drainQueue()
+			return
+		}
+	}
+}
+
+// drainQueue closes the send queue and fails every message still waiting in
+// it.
+func (p *Peer) drainQueue() {
+	p.queueMtx.Lock()
+	defer p.queueMtx.Unlock()
+
+	p.queueClosed = true
+	for {
+		select {
+		case out := <-p.outgoing:
+			if out.done != nil {
+				out.done <- ErrPeerDisconnected
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package peer
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"handshake/common"
+	"handshake/message"
+)
+
+// connectedPeers returns both ends of a completed handshake wrapped as peers
+func connectedPeers(t *testing.T) (*Peer, *Peer) {
+	server := listenLocal(t, true)
+	defer server.Close()
+	accepted := acceptAsync(server)
+
+	cfg := DefaultHandshakeConfig()
+	cfg.AllowSelfConns = true
+	res, err := HandshakeWithConfig(server.Addr().String(), common.SimNet, ProtocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("handshake failed: %+v", err)
+	}
+
+	inbound := <-accepted
+	if inbound.err != nil {
+		t.Fatalf("server rejected the peer: %+v", inbound.err)
+	}
+
+	return NewPeer(res), NewPeer(inbound.res)
+}
+
+func TestPeerMessageExchange(t *testing.T) {
+	outbound, inbound := connectedPeers(t)
+	defer outbound.Disconnect()
+	defer inbound.Disconnect()
+
+	received := make(chan message.Message, 1)
+	outbound.Handle(message.CmdWtxidRelay, func(p *Peer, msg message.Message) {
+		if p != outbound {
+			t.Errorf("handler called with the wrong peer")
+		}
+		received <- msg
+	})
+	outbound.Start()
+	inbound.Start()
+
+	done := make(chan error, 1)
+	inbound.QueueMessage(&message.MsgWtxidRelay{}, done)
+	if err := <-done; err != nil {
+		t.Fatalf("sending failed: %+v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if _, ok := msg.(*message.MsgWtxidRelay); !ok {
+			t.Errorf("unexpected message %T", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("message not dispatched to the handler")
+	}
+}
+
+func TestPeerDisconnect(t *testing.T) {
+	outbound, inbound := connectedPeers(t)
+	outbound.Start()
+	inbound.Start()
+
+	if outbound.DisconnectReason() != nil {
+		t.Errorf("connected peer shouldn't have a disconnect reason")
+	}
+
+	outbound.Disconnect()
+	<-outbound.Done()
+	if outbound.DisconnectReason() != ErrPeerDisconnected {
+		t.Errorf("unexpected local disconnect reason %+v", outbound.DisconnectReason())
+	}
+
+	select {
+	case <-inbound.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("remote peer didn't notice the disconnect")
+	}
+	if inbound.DisconnectReason() != io.EOF {
+		t.Errorf("unexpected remote disconnect reason %+v", inbound.DisconnectReason())
+	}
+
+	// nothing can be sent anymore
+	done := make(chan error, 1)
+	outbound.QueueMessage(&message.MsgVerAck{}, done)
+	if err := <-done; err != ErrPeerDisconnected {
+		t.Errorf("expected ErrPeerDisconnected, got %+v", err)
+	}
+}
+
+func TestPeerDisconnectBeforeStart(t *testing.T) {
+	outbound, inbound := connectedPeers(t)
+	defer inbound.Disconnect()
+
+	queued := make(chan error, 1)
+	outbound.QueueMessage(&message.MsgWtxidRelay{}, queued)
+	outbound.Disconnect()
+
+	select {
+	case <-outbound.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("done not closed for a peer which was never started")
+	}
+	if err := <-queued; err != ErrPeerDisconnected {
+		t.Errorf("queued message should fail with ErrPeerDisconnected, got %+v", err)
+	}
+
+	// starting it afterwards doesn't revive it
+	outbound.Start()
+	if err := outbound.DisconnectReason(); err != ErrPeerDisconnected {
+		t.Errorf("unexpected disconnect reason %+v", err)
+	}
+}
+
+func TestPeerAddrV2Negotiation(t *testing.T) {
+	outbound, inbound := connectedPeers(t)
+	defer outbound.Disconnect()
+	defer inbound.Disconnect()
+
+	received := make(chan *message.MsgAddrV2, 1)
+	outbound.Handle(message.CmdAddrV2, func(p *Peer, msg message.Message) {
+		received <- msg.(*message.MsgAddrV2)
+	})
+	outbound.Start()
+	inbound.Start()
+
+	msg := &message.MsgAddrV2{}
+	msg.AddAddress(&common.NetAddressV2{
+		NetworkID: common.NetIDTorV3,
+		Addr:      make([]byte, 32),
+		Port:      8333,
+	})
+
+	done := make(chan error, 1)
+	inbound.QueueMessage(msg, done)
+	if err := <-done; err != nil {
+		t.Fatalf("sending failed: %+v", err)
+	}
+
+	select {
+	case got := <-received:
+		if len(got.AddrList) != 1 || got.AddrList[0].NetworkID != common.NetIDTorV3 {
+			t.Errorf("unexpected addresses %+v", got.AddrList)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("addrv2 not dispatched to the handler")
+	}
+
+	// a peer which didn't negotiate addrv2 must not be sent one
+	inbound.Result().Features.AddrV2 = false
+	inbound.QueueMessage(msg, done)
+	if err := <-done; err != ErrAddrV2NotNegotiated {
+		t.Errorf("expected ErrAddrV2NotNegotiated, got %+v", err)
+	}
+}
+
+func TestPeerKeepAlive(t *testing.T) {
+	outbound, inbound := connectedPeers(t)
+	defer outbound.Disconnect()
+	defer inbound.Disconnect()
+
+	outbound.KeepAlive(20*time.Millisecond, time.Second)
+	outbound.Start()
+	inbound.Start()
+
+	deadline := time.Now().Add(time.Second)
+	for outbound.PingStats().Count < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pings weren't answered: %+v", outbound.PingStats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := outbound.PingStats()
+	if stats.Last <= 0 || stats.Min <= 0 || stats.Avg < stats.Min {
+		t.Errorf("inconsistent ping stats %+v", stats)
+	}
+}
+
+func TestPeerPingTimeout(t *testing.T) {
+	outbound, inbound := connectedPeers(t)
+	defer inbound.Disconnect()
+
+	// the inbound peer is never started so the ping stays unanswered
+	outbound.KeepAlive(10*time.Millisecond, 50*time.Millisecond)
+	outbound.Start()
+
+	select {
+	case <-outbound.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("peer not disconnected on ping timeout")
+	}
+	if outbound.DisconnectReason() != ErrPingTimeout {
+		t.Errorf("unexpected disconnect reason %+v", outbound.DisconnectReason())
+	}
+}
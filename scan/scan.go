@@ -0,0 +1,238 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"handshake/checker"
+	"handshake/common"
+	"handshake/message"
+	"handshake/peer"
+)
+
+const (
+	// DefaultWorkers is the number of concurrent handshakes when
+	// Config.Workers is zero.
+	DefaultWorkers = 16
+
+	// DefaultPingTimeout is how long we wait for the pong measuring the
+	// round trip time when Config.PingTimeout is zero.
+	DefaultPingTimeout = 5 * time.Second
+)
+
+// Config describes how to scan nodes.
+type Config struct {
+	// Network the nodes run on.
+	Network common.BitcoinNet
+
+	// ProtocolVersion we announce in our version messages.
+	ProtocolVersion uint32
+
+	// Workers bounds the number of handshakes in progress.
+	// DefaultWorkers is used when it is zero.
+	Workers int
+
+	// Rate bounds the number of handshakes started per second across all
+	// workers.  Zero means no limit.
+	Rate float64
+
+	// PingTimeout bounds waiting for the pong measuring the round trip
+	// time after the handshake.  DefaultPingTimeout is used when it is
+	// zero.
+	PingTimeout time.Duration
+
+	// Handshake configures the handshakes.  DefaultHandshakeConfig is used
+	// when it is nil.
+	Handshake *peer.HandshakeConfig
+}
+
+// Result is the outcome of the handshake with a single node.
+type Result struct {
+	// Address as it was read from the input.
+	Address string
+
+	// What the node announced in its version message.
+	UserAgent       string
+	ProtocolVersion uint32
+	Services        common.ServiceFlag
+	StartHeight     int32
+
+	// HandshakeTime is the time spent on the handshake, dial included.
+	HandshakeTime time.Duration
+
+	// RTT is the round trip time of a ping after the handshake.
+	RTT time.Duration
+
+	// Err is why the handshake or the ping failed.  The fields above are
+	// set when only the ping failed.
+	Err error
+}
+
+// Summary counts the results of a scan.
+type Summary struct {
+	Total     int
+	Succeeded int
+	Failed    int
+
+	// AvgRTT is the mean round trip time of the nodes which succeeded.
+	AvgRTT time.Duration
+
+	totalRTT time.Duration
+}
+
+// Add counts res.
+func (s *Summary) Add(res *Result) {
+	s.Total++
+	if res.Err != nil {
+		s.Failed++
+		return
+	}
+
+	s.Succeeded++
+	s.totalRTT += res.RTT
+	s.AvgRTT = s.totalRTT / time.Duration(s.Succeeded)
+}
+
+// Scan handshakes with every address received from addrs and sends the
+// results, in the order the handshakes finish.  The results channel is closed
+// once addrs is closed and every handshake finished, or early when ctx is
+// done.  The caller must receive every result.
+func Scan(ctx context.Context, addrs <-chan string, cfg *Config) <-chan Result {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	jobs := make(chan string)
+	results := make(chan Result)
+	go dispatch(ctx, addrs, jobs, cfg.Rate)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for addr := range jobs {
+				results <- scanNode(ctx, addr, cfg)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// dispatch hands the addresses over to the workers, no more than rate per
+// second when rate is positive.
+func dispatch(ctx context.Context, addrs <-chan string, jobs chan<- string, rate float64) {
+	defer close(jobs)
+
+	var tick <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for first := true; ; first = false {
+		var addr string
+		select {
+		case a, ok := <-addrs:
+			if !ok {
+				return
+			}
+			addr = a
+		case <-ctx.Done():
+			return
+		}
+
+		// The first handshake starts right away.
+		if tick != nil && !first {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case jobs <- addr:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scanNode handshakes with addr and measures the round trip time.
+func scanNode(ctx context.Context, addr string, cfg *Config) Result {
+	hcfg := cfg.Handshake
+	if hcfg == nil {
+		hcfg = peer.DefaultHandshakeConfig()
+	}
+
+	res := Result{Address: addr}
+	hres, err := peer.HandshakeContext(ctx, addr, cfg.Network, cfg.ProtocolVersion, hcfg)
+	if err != nil {
+		log.Debugf("Handshake with %s failed: %v", addr, err)
+		res.Err = err
+		return res
+	}
+	defer hres.Conn.Close()
+
+	res.UserAgent = hres.UserAgent
+	res.ProtocolVersion = hres.RemoteProtocolVersion
+	res.Services = hres.Services
+	res.StartHeight = hres.StartHeight
+	res.HandshakeTime = hres.Timings.Total
+
+	// Older nodes don't answer pings with pongs, the version exchange is
+	// the best estimate then.
+	if hres.NegotiatedVersion <= message.BIP0031Version {
+		res.RTT = hres.Timings.Version
+		return res
+	}
+
+	timeout := cfg.PingTimeout
+	if timeout <= 0 {
+		timeout = DefaultPingTimeout
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res.RTT, res.Err = checker.MeasureRTTContext(pingCtx, hres.Conn,
+		hres.NegotiatedVersion, cfg.Network)
+	if res.Err != nil {
+		log.Debugf("Ping to %s failed: %v", addr, res.Err)
+	}
+	return res
+}
+
+// ReadAddresses sends the addresses read from r, one per line, to addrs and
+// closes it.  Blank lines and lines starting with # are skipped.  It gives up
+// when ctx is done.
+func ReadAddresses(ctx context.Context, r io.Reader, addrs chan<- string) error {
+	defer close(addrs)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		select {
+		case addrs <- line:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
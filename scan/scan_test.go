@@ -0,0 +1,171 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"handshake/common"
+	"handshake/peer"
+)
+
+const protocolVersion = 70016
+
+// servePeers runs a simnet node built from our own server and peer.
+func servePeers(t *testing.T) *peer.Server {
+	cfg := peer.DefaultHandshakeConfig()
+	cfg.AllowSelfConns = true
+	server, err := peer.Listen("127.0.0.1:0", common.SimNet, protocolVersion, cfg)
+	if err != nil {
+		t.Fatalf("couldn't listen %+v", err)
+	}
+
+	go func() {
+		for {
+			res, err := server.Accept()
+			if err != nil {
+				if _, ok := err.(*peer.RejectError); ok {
+					continue
+				}
+				return
+			}
+			p := peer.NewPeer(res)
+			p.Start()
+			time.AfterFunc(5*time.Second, p.Disconnect)
+		}
+	}()
+
+	return server
+}
+
+// testConfig returns a scan config for the nodes served by servePeers.
+func testConfig() *Config {
+	hcfg := peer.DefaultHandshakeConfig()
+	hcfg.RetryPolicy = nil
+	hcfg.AllowSelfConns = true
+	return &Config{
+		Network:         common.SimNet,
+		ProtocolVersion: protocolVersion,
+		Workers:         2,
+		PingTimeout:     time.Second,
+		Handshake:       hcfg,
+	}
+}
+
+// scanAll scans every line of input and returns the results by address.
+func scanAll(t *testing.T, input string, cfg *Config) map[string]Result {
+	ctx := context.Background()
+	addrs := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ReadAddresses(ctx, strings.NewReader(input), addrs)
+	}()
+
+	results := make(map[string]Result)
+	for res := range Scan(ctx, addrs, cfg) {
+		results[res.Address] = res
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ReadAddresses: %v", err)
+	}
+	return results
+}
+
+func TestScan(t *testing.T) {
+	server := servePeers(t)
+	defer server.Close()
+
+	good := server.Addr().String()
+	input := strings.Join([]string{
+		"# nodes to scan",
+		good,
+		"",
+		"127.0.0.1:1",
+		"not an address:x",
+	}, "\n")
+	results := scanAll(t, input, testConfig())
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d: %+v", len(results), results)
+	}
+
+	res := results[good]
+	if res.Err != nil {
+		t.Fatalf("scan of %s failed: %v", good, res.Err)
+	}
+	if res.UserAgent != peer.DefaultUserAgent || res.ProtocolVersion != protocolVersion {
+		t.Errorf("unexpected version details %+v", res)
+	}
+	if res.RTT <= 0 || res.HandshakeTime <= 0 {
+		t.Errorf("unexpected timings %+v", res)
+	}
+
+	if results["127.0.0.1:1"].Err == nil {
+		t.Errorf("expected refused connection to fail")
+	}
+	if err := results["not an address:x"].Err; !errors.Is(err, peer.ErrInvalidAddress) {
+		t.Errorf("expected invalid address, got %v", err)
+	}
+
+	var summary Summary
+	for _, res := range results {
+		summary.Add(&res)
+	}
+	if summary.Total != 3 || summary.Succeeded != 1 || summary.Failed != 2 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if summary.AvgRTT != res.RTT {
+		t.Errorf("average RTT: got %v, want %v", summary.AvgRTT, res.RTT)
+	}
+}
+
+func TestScanRate(t *testing.T) {
+	server := servePeers(t)
+	defer server.Close()
+
+	addr := server.Addr().String()
+	cfg := testConfig()
+	cfg.Workers = 4
+	cfg.Rate = 20
+
+	input := strings.Repeat(addr+"\n", 4)
+	start := time.Now()
+	ctx := context.Background()
+	addrs := make(chan string)
+	go ReadAddresses(ctx, strings.NewReader(input), addrs)
+
+	count := 0
+	for res := range Scan(ctx, addrs, cfg) {
+		if res.Err != nil {
+			t.Errorf("scan of %s failed: %v", res.Address, res.Err)
+		}
+		count++
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 results, got %d", count)
+	}
+
+	// The first handshake starts right away, the others one interval
+	// apart.
+	if elapsed := time.Since(start); elapsed < 3*time.Second/20 {
+		t.Errorf("4 handshakes at 20 per second took only %v", elapsed)
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	addrs := make(chan string)
+	results := Scan(ctx, addrs, testConfig())
+
+	cancel()
+	select {
+	case _, ok := <-results:
+		if ok {
+			t.Fatalf("unexpected result after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("scan didn't stop after cancel")
+	}
+}